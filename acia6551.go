@@ -1,5 +1,12 @@
 package i6502
 
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
 const (
 	aciaData = iota
 	aciaStatus
@@ -7,6 +14,20 @@ const (
 	aciaControl
 )
 
+// Default clock speed of the Cpu, in Hz, used to time serial transfers.
+const aciaDefaultCpuClock = 1000000
+
+// Number of bytes buffered in each direction between the ACIA and the host.
+const aciaBufferSize = 4096
+
+// Returned by read when it is cancelled.
+var errReadCancelled = errors.New("Read cancelled")
+
+// Baud rates selected by the lower 4 bits of the control register. 0
+// selects the external receiver clock, which is not emulated: data is
+// transferred instantly.
+var aciaBaudRates = [16]float64{0, 50, 75, 109.92, 134.58, 150, 300, 600, 1200, 1800, 2400, 3600, 4800, 7200, 9600, 19200}
+
 /*
 ACIA 6551 Serial IO
 
@@ -15,8 +36,37 @@ directly attached to the 6502's address and data busses.
 
 It provides serial IO.
 
-The supplied Rx and Tx channels can be used to read and wirte
-data to the ACIA 6551.
+On the host side, the ACIA is an io.ReadWriteCloser. Read returns the
+data transmitted by the Cpu, Write sends data to the Cpu. Both are
+buffered and safe to use from other goroutines: the emulated Cpu never
+blocks on the host. Read blocks until data is available, Write blocks
+while the receive buffer is full. After Close, Read returns io.EOF once
+the buffer is drained.
+
+For compatibility, transmitted data is sent to the output channel, one
+byte at a time, if one is given to NewAcia6551. Don't use Read as well.
+The channel belongs to the caller: the ACIA stops sending when closed, but
+does not close the channel.
+
+With flow control enabled, the ACIA only receives data while RTS is
+asserted in the command register, and only transmits while the host
+asserts CTS and there is room in the transmit buffer. Without flow
+control, data transmitted while the buffer is full is lost.
+
+When enabled in the command register, the ACIA interrupts when a byte is
+received or the transmitter is empty, on the IrqLine given to AttachIrq.
+Bit 7 of the status register is set until the status register is read.
+
+The Cpu asserts DTR in the command register when it's ready. The host
+drives the DSR and DCD inputs, shown in the status register. Changes on
+these inputs interrupt like received data.
+
+The ACIA is Clocked. When a baud rate is selected in the control register,
+transfers take as many Cpu cycles as a real serial line would: the
+transmitter is busy until a byte has been sent, and bytes written to the
+ACIA arrive one by one. Without a baud rate, data is transferred instantly:
+each byte written to the ACIA arrives as soon as the Cpu has read the
+previous one.
 */
 type Acia6551 struct {
 	rx byte
@@ -33,13 +83,44 @@ type Acia6551 struct {
 
 	overrun bool
 
+	irqRequest bool     // Interrupt occurred, status bit 7
+	irq        *IrqLine // Line to interrupt the Cpu
+
+	cpuClock int // Clock speed of the Cpu, in Hz
+	txCycles int // Cycles until the byte in tx has been sent
+	rxCycles int // Cycles until the next byte in rxBuffer arrives
+
+	mutex    sync.Mutex
+	changed  *sync.Cond // Signalled when the buffers change or the ACIA is closed
+	txBuffer []byte     // Transmitted, waiting for the host to Read
+	rxBuffer []byte     // Written by the host, on its way to rx
+	closed   bool
+	done     chan struct{} // Closed by Close, stops sending to output
+	active   int32 // Non-zero while a transfer is in progress, checked by Tick without locking
+
+	flowControl bool // Honour RTS and CTS
+	cts         bool // Clear To Send, driven by the host
+	dsr         bool // Data Set Ready, driven by the host
+	dcd         bool // Data Carrier Detect, driven by the host
+
 	output chan []byte
 }
 
+/*
+Create a new Acia6551. If output is not nil, transmitted data is sent to
+it, one byte at a time, until the ACIA is closed. The output channel is
+not closed.
+*/
 func NewAcia6551(output chan []byte) (*Acia6551, error) {
-	acia := &Acia6551{output: output}
+	acia := &Acia6551{output: output, cpuClock: aciaDefaultCpuClock, cts: true, dsr: true, dcd: true}
+	acia.done = make(chan struct{})
+	acia.changed = sync.NewCond(&acia.mutex)
 	acia.Reset()
 
+	if output != nil {
+		go acia.pump()
+	}
+
 	return acia, nil
 }
 
@@ -48,8 +129,94 @@ func (a *Acia6551) Size() uint16 {
 	return 0x04
 }
 
+// Set the clock speed of the Cpu in Hz, 1MHz by default, to time serial
+// transfers at the selected baud rate.
+func (a *Acia6551) SetCpuClock(hz int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.cpuClock = hz
+}
+
+// Enable or disable RTS/CTS flow control.
+func (a *Acia6551) SetFlowControl(enabled bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.flowControl = enabled
+	a.receive()
+	a.transmit()
+}
+
+// Drive the CTS input. The host deasserts CTS to stop the ACIA from
+// transmitting, when flow control is enabled.
+func (a *Acia6551) SetCTS(asserted bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.cts = asserted
+	a.transmit()
+}
+
+// Returns whether the Cpu asserts RTS in the command register, to signal
+// it's ready to receive data.
+func (a *Acia6551) RTS() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.rts()
+}
+
+func (a *Acia6551) rts() bool {
+	return a.commandData&0x0C != 0
+}
+
+// Returns whether the Cpu asserts DTR in the command register, to signal
+// the terminal is ready.
+func (a *Acia6551) DTR() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.commandData&0x01 != 0
+}
+
+// Drive the DSR input, shown in bit 6 of the status register.
+func (a *Acia6551) SetDSR(asserted bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.setModemLine(&a.dsr, asserted)
+}
+
+// Drive the DCD input, shown in bit 5 of the status register.
+func (a *Acia6551) SetDCD(asserted bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.setModemLine(&a.dcd, asserted)
+}
+
+// Changes on the modem lines interrupt when receive interrupts are enabled.
+func (a *Acia6551) setModemLine(line *bool, asserted bool) {
+	if *line == asserted {
+		return
+	}
+	*line = asserted
+
+	if a.rxIrqEnabled {
+		a.interrupt()
+	}
+}
+
 // Emulates a hardware reset
 func (a *Acia6551) Reset() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.reset()
+}
+
+func (a *Acia6551) reset() {
 	a.rx = 0
 	a.rxFull = false
 
@@ -61,6 +228,8 @@ func (a *Acia6551) Reset() {
 
 	a.overrun = false
 
+	a.clearIrq()
+
 	a.setControl(0)
 	a.setCommand(0)
 }
@@ -69,11 +238,56 @@ func (a *Acia6551) setControl(data byte) {
 	a.controlData = data
 }
 
+// Bit 0 (DTR) enables interrupts. Bit 1 disables the receiver interrupt,
+// bits 2-3 set to 01 enable the transmitter interrupt.
 func (a *Acia6551) setCommand(data byte) {
+	a.decodeCommand(data)
+
+	if a.txIrqEnabled && a.txEmpty {
+		a.interrupt()
+	}
+
+	// RTS may have changed
+	a.receive()
+}
+
+func (a *Acia6551) decodeCommand(data byte) {
 	a.commandData = data
 
-	a.rxIrqEnabled = (data & 0x02) != 0
-	a.txIrqEnabled = ((data & 0x04) != 0) && ((data & 0x08) != 1)
+	dtr := (data & 0x01) != 0
+	a.rxIrqEnabled = dtr && (data&0x02) == 0
+	a.txIrqEnabled = dtr && (data&0x0C) == 0x04
+}
+
+// Signal the IRQ line when the ACIA interrupts. Interrupts must also be
+// enabled in the command register.
+func (a *Acia6551) AttachIrq(line *IrqLine) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.irq = line
+
+	if a.irqRequest {
+		a.irq.Assert(a)
+	}
+}
+
+// Sets the IRQ bit in the status register and asserts the IRQ line.
+func (a *Acia6551) interrupt() {
+	a.irqRequest = true
+
+	if a.irq != nil {
+		a.irq.Assert(a)
+	}
+}
+
+// Clears the IRQ bit in the status register and releases the IRQ line.
+func (a *Acia6551) clearIrq() {
+	a.irqRequest = false
+
+	if a.irq != nil {
+		a.irq.Release(a)
+	}
 }
 
 func (a *Acia6551) statusRegister() byte {
@@ -91,41 +305,287 @@ func (a *Acia6551) statusRegister() byte {
 		status |= 0x04
 	}
 
+	// DCD and DSR are active low
+	if !a.dcd {
+		status |= 0x20
+	}
+
+	if !a.dsr {
+		status |= 0x40
+	}
+
+	if a.irqRequest {
+		status |= 0x80
+	}
+
 	return status
 }
 
 // Implements io.Reader, for external programs to read TX'ed data from
-// the serial output.
+// the serial output. Blocks until data is available, or returns io.EOF
+// when the ACIA is closed and all data has been read.
 func (a *Acia6551) Read(p []byte) (n int, err error) {
+	return a.read(p, nil)
+}
+
+// Reads like Read, but returns errReadCancelled without reading anything
+// once cancel is closed. Used by bridges, to stop reading when closed.
+func (a *Acia6551) read(p []byte, cancel <-chan struct{}) (n int, err error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
 
-	if a.txEmpty {
-		return 0, nil
-	} else {
-		a.txEmpty = true
-		copy(p, []byte{a.tx})
-		// TODO: Handle txInterrupt
-		return 1, nil
+	if cancel != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go a.wakeOnCancel(cancel, done)
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for len(a.txBuffer) == 0 && !a.closed && !isCancelled(cancel) {
+		a.changed.Wait()
+	}
+
+	if isCancelled(cancel) {
+		return 0, errReadCancelled
+	}
+
+	if len(a.txBuffer) == 0 {
+		return 0, io.EOF
+	}
+
+	n = copy(p, a.txBuffer)
+	a.txBuffer = a.txBuffer[n:]
+
+	// Room for a transmitter held back by flow control
+	a.transmit()
+
+	return n, nil
+}
+
+// Wakes up a blocked read when cancel is closed, until done is closed.
+func (a *Acia6551) wakeOnCancel(cancel <-chan struct{}, done <-chan struct{}) {
+	select {
+	case <-cancel:
+		a.mutex.Lock()
+		a.changed.Broadcast()
+		a.mutex.Unlock()
+	case <-done:
+	}
+}
+
+func isCancelled(cancel <-chan struct{}) bool {
+	select {
+	case <-cancel:
+		return true
+	default:
+		return false
 	}
 }
 
 // Implements io.Writer, for external programs to write to the
-// ACIA's RX
+// ACIA's RX. When a baud rate is selected, the bytes arrive over time,
+// otherwise as the Cpu reads them. Blocks while the receive buffer is full.
 func (a *Acia6551) Write(p []byte) (n int, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
 	for _, b := range p {
-		a.rxWrite(b)
+		for len(a.rxBuffer) >= aciaBufferSize && !a.closed {
+			a.changed.Wait()
+		}
+
+		if a.closed {
+			return n, io.ErrClosedPipe
+		}
+
+		if len(a.rxBuffer) == 0 {
+			a.rxCycles = a.frameCycles()
+		}
+		a.rxBuffer = append(a.rxBuffer, b)
+		atomic.StoreInt32(&a.active, 1)
+		n++
+
+		a.receive()
+	}
+
+	return n, nil
+}
+
+// Implements io.Closer. Blocked calls to Read and Write return, and
+// further data transmitted by the Cpu is lost.
+func (a *Acia6551) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if !a.closed {
+		a.closed = true
+		close(a.done)
 	}
+	a.changed.Broadcast()
 
-	return len(p), nil
+	return nil
+}
+
+// Sends transmitted data to the output channel, until the ACIA is closed.
+func (a *Acia6551) pump() {
+	buffer := make([]byte, 1)
+	for {
+		if _, err := a.Read(buffer); err != nil {
+			return
+		}
+
+		select {
+		case a.output <- []byte{buffer[0]}:
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// Let the given number of clock cycles pass, to time serial transfers.
+func (a *Acia6551) Tick(cycles uint8) {
+	if atomic.LoadInt32(&a.active) == 0 {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if !a.txEmpty {
+		a.txCycles -= int(cycles)
+		a.transmit()
+	}
+
+	// The host holds data while RTS is deasserted
+	if len(a.rxBuffer) > 0 && !a.rxHeld() {
+		a.rxCycles -= int(cycles)
+		a.receive()
+	}
+
+	if a.txEmpty && len(a.rxBuffer) == 0 {
+		atomic.StoreInt32(&a.active, 0)
+	}
+}
+
+// Moves the byte in tx to the transmit buffer, when it has been sent and
+// flow control allows it.
+func (a *Acia6551) transmit() {
+	if a.txEmpty || a.txCycles > 0 {
+		return
+	}
+
+	if a.flowControl && (!a.cts || len(a.txBuffer) >= aciaBufferSize) {
+		return
+	}
+
+	if !a.closed && len(a.txBuffer) < aciaBufferSize {
+		a.txBuffer = append(a.txBuffer, a.tx)
+		a.changed.Broadcast()
+	}
+
+	a.txEmpty = true
+
+	if a.txIrqEnabled {
+		a.interrupt()
+	}
+}
+
+// Moves bytes that have arrived from the receive buffer to rx, when flow
+// control allows it. Without a baud rate, the next byte arrives once rx
+// has been read.
+func (a *Acia6551) receive() {
+	if a.rxHeld() {
+		return
+	}
+
+	frame := a.frameCycles()
+	if frame == 0 {
+		if len(a.rxBuffer) > 0 && !a.rxFull {
+			a.rxNext()
+		}
+		return
+	}
+
+	for len(a.rxBuffer) > 0 && a.rxCycles <= 0 {
+		a.rxNext()
+		a.rxCycles += frame
+	}
+}
+
+// Moves the next byte from the receive buffer to rx.
+func (a *Acia6551) rxNext() {
+	a.rxWrite(a.rxBuffer[0])
+	a.rxBuffer = a.rxBuffer[1:]
+	a.changed.Broadcast()
+}
+
+// Returns whether flow control stops the host from sending.
+func (a *Acia6551) rxHeld() bool {
+	return a.flowControl && !a.rts()
+}
+
+// Returns the number of Cpu cycles needed to transfer a single byte, with
+// start, stop and parity bits, or 0 when no baud rate is selected.
+func (a *Acia6551) frameCycles() int {
+	baud := aciaBaudRates[a.controlData&0x0F]
+	if baud == 0 {
+		return 0
+	}
+
+	bits := 1 + a.wordLength() + 1
+	if a.controlData&0x80 != 0 {
+		bits++ // Second stop bit
+	}
+	if a.commandData&0x20 != 0 {
+		bits++ // Parity
+	}
+
+	return int(float64(a.cpuClock) * float64(bits) / baud)
+}
+
+// Returns the number of data bits, 5 to 8.
+func (a *Acia6551) wordLength() int {
+	return 8 - int(a.controlData>>5&0x03)
+}
+
+// Masks the bits that don't fit in the word length.
+func (a *Acia6551) word(data byte) byte {
+	return data & (0xFF >> uint(8-a.wordLength()))
 }
 
 // Used by the AddressBus to read data from the ACIA 6551
 func (a *Acia6551) ReadByte(address uint16) byte {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
 	switch address {
 	case aciaData:
 		return a.rxRead()
+	case aciaStatus:
+		status := a.statusRegister()
+		a.clearIrq()
+		return status
+	}
+
+	return a.peek(address)
+}
+
+// Returns the same as ReadByte, without clearing the receive status or
+// the interrupt.
+func (a *Acia6551) Peek(address uint16) byte {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return a.peek(address)
+}
+
+func (a *Acia6551) peek(address uint16) byte {
+	switch address {
+	case aciaData:
+		return a.rx
 	case aciaStatus:
 		return a.statusRegister()
 	case aciaCommand:
@@ -139,11 +599,14 @@ func (a *Acia6551) ReadByte(address uint16) byte {
 
 // Used by the AddressBus to write data to the ACIA 6551
 func (a *Acia6551) WriteByte(address uint16, data byte) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
 	switch address {
 	case aciaData:
 		a.txWrite(data)
 	case aciaStatus:
-		a.Reset()
+		a.reset()
 	case aciaCommand:
 		a.setCommand(data)
 	case aciaControl:
@@ -151,10 +614,33 @@ func (a *Acia6551) WriteByte(address uint16, data byte) {
 	}
 }
 
+// Sets the registers read by Peek, for debuggers. Poking the data register
+// sets the received byte without transmitting, and the command register
+// does not interrupt or receive. The status register can't be poked.
+func (a *Acia6551) Poke(address uint16, data byte) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	switch address {
+	case aciaData:
+		a.rx = data
+	case aciaCommand:
+		a.decodeCommand(data)
+	case aciaControl:
+		a.controlData = data
+	}
+}
+
 func (a *Acia6551) rxRead() byte {
+	data := a.rx
+
 	a.overrun = false
 	a.rxFull = false
-	return a.rx
+
+	// Makes room for the next byte, without a baud rate
+	a.receive()
+
+	return data
 }
 
 func (a *Acia6551) rxWrite(data byte) {
@@ -163,14 +649,24 @@ func (a *Acia6551) rxWrite(data byte) {
 		a.overrun = true
 	}
 
-	a.rx = data
+	a.rx = a.word(data)
 	a.rxFull = true
 
-	// TODO: Interrupts
+	if a.rxIrqEnabled {
+		a.interrupt()
+	}
 }
 
+// Starts sending data. Writing while the transmitter is busy replaces the
+// byte being sent.
 func (a *Acia6551) txWrite(data byte) {
-	a.output <- []byte{data}
-	a.tx = data
-	// a.txEmpty = false
+	a.tx = a.word(data)
+
+	if a.txEmpty {
+		a.txEmpty = false
+		a.txCycles = a.frameCycles()
+		atomic.StoreInt32(&a.active, 1)
+	}
+
+	a.transmit()
 }
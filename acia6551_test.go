@@ -1,7 +1,11 @@
 package i6502
 
 import (
+	"io"
+	"io/ioutil"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,6 +26,7 @@ func TestNewAcia6551(t *testing.T) {
 
 func TestAciaAsMemory(t *testing.T) {
 	assert.Implements(t, (*Memory)(nil), new(Acia6551))
+	assert.Implements(t, (*io.ReadWriteCloser)(nil), new(Acia6551))
 }
 
 func TestAciaReset(t *testing.T) {
@@ -43,15 +48,18 @@ func TestAciaReset(t *testing.T) {
 }
 
 func TestAciaReaderWithTxEmpty(t *testing.T) {
-	a, _ := AciaSubject()
+	a, _ := NewAcia6551(nil)
 
 	// Nothing to read
 	assert.True(t, a.txEmpty)
 
+	a.Close()
+
 	value := make([]byte, 1)
-	bytesRead, _ := a.Read(value)
+	bytesRead, err := a.Read(value)
 
 	assert.EqualValues(t, 0, bytesRead)
+	assert.Equal(t, io.EOF, err)
 }
 
 func TestAciaWriteByteAndReader(t *testing.T) {
@@ -83,32 +91,52 @@ func TestAciaWriterAndReadByte(t *testing.T) {
 		assert.EqualValues(t, 0x42, a.ReadByte(aciaData))
 	}
 
-	// System writes multiple bytes
+	// System writes multiple bytes, they arrive as the Cpu reads them
 	bytesWritten, _ = a.Write([]byte{0x42, 0x32, 0xAB})
 
 	if assert.EqualValues(t, 3, bytesWritten) {
+		assert.EqualValues(t, 0x42, a.ReadByte(aciaData))
+		assert.EqualValues(t, 0x32, a.ReadByte(aciaData))
 		assert.EqualValues(t, 0xAB, a.ReadByte(aciaData))
 	}
 }
 
+func TestAciaReceiveWithoutBaudRate(t *testing.T) {
+	a, _ := AciaSubject()
+	a.Write([]byte("hello"))
+
+	var received []byte
+	for a.ReadByte(aciaStatus)&0x08 != 0 {
+		assert.EqualValues(t, 0x00, a.Peek(aciaStatus)&0x04)
+		received = append(received, a.ReadByte(aciaData))
+	}
+
+	assert.Equal(t, []byte("hello"), received)
+	assert.Len(t, a.rxBuffer, 0)
+}
+
 func TestAciaCommandRegister(t *testing.T) {
 	a, _ := AciaSubject()
 	assert.False(t, a.rxIrqEnabled)
 	assert.False(t, a.txIrqEnabled)
 
-	a.WriteByte(aciaCommand, 0x02) // b0000 0010 RX Irq enabled
+	a.WriteByte(aciaCommand, 0x09) // b0000 1001 RX Irq enabled
 	assert.True(t, a.rxIrqEnabled)
 	assert.False(t, a.txIrqEnabled)
 
-	a.WriteByte(aciaCommand, 0x04) // b0000 0100 TX Irq enabled
+	a.WriteByte(aciaCommand, 0x07) // b0000 0111 TX Irq enabled
 	assert.False(t, a.rxIrqEnabled)
 	assert.True(t, a.txIrqEnabled)
 
-	a.WriteByte(aciaCommand, 0x06) // b0000 0110 RX + TX Irq enabled
+	a.WriteByte(aciaCommand, 0x05) // b0000 0101 RX + TX Irq enabled
 	assert.True(t, a.rxIrqEnabled)
 	assert.True(t, a.txIrqEnabled)
 
-	assert.EqualValues(t, 0x06, a.ReadByte(aciaCommand))
+	a.WriteByte(aciaCommand, 0x04) // b0000 0100 DTR off, no Irqs
+	assert.False(t, a.rxIrqEnabled)
+	assert.False(t, a.txIrqEnabled)
+
+	assert.EqualValues(t, 0x04, a.ReadByte(aciaCommand))
 }
 
 func TestAciaControlRegister(t *testing.T) {
@@ -142,6 +170,98 @@ func TestAciaStatusRegister(t *testing.T) {
 	assert.EqualValues(t, 0x04, a.ReadByte(aciaStatus))
 }
 
+func TestAciaRxInterrupt(t *testing.T) {
+	a, _ := AciaSubject()
+	irq, _ := NewIrqLine()
+	a.AttachIrq(irq)
+
+	// Disabled
+	a.Write([]byte{0x42})
+	assert.False(t, irq.Asserted())
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x80)
+
+	a.WriteByte(aciaCommand, 0x0B)
+	a.ReadByte(aciaData)
+	a.Write([]byte{0x42})
+	assert.False(t, irq.Asserted())
+
+	a.WriteByte(aciaCommand, 0x09)
+	a.ReadByte(aciaData)
+	a.Write([]byte{0x43})
+	assert.True(t, irq.Asserted())
+
+	// Peeking leaves the interrupt
+	assert.EqualValues(t, 0x80, a.Peek(aciaStatus)&0x80)
+	assert.True(t, irq.Asserted())
+
+	// Reading the status register clears it
+	assert.EqualValues(t, 0x80, a.ReadByte(aciaStatus)&0x80)
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x80)
+	assert.False(t, irq.Asserted())
+}
+
+func TestAciaTxInterrupt(t *testing.T) {
+	a, o := AciaSubject()
+	irq, _ := NewIrqLine()
+	a.AttachIrq(irq)
+
+	// Enabling interrupts with an empty transmitter interrupts right away
+	a.WriteByte(aciaCommand, 0x05)
+	assert.True(t, irq.Asserted())
+	a.ReadByte(aciaStatus)
+	assert.False(t, irq.Asserted())
+
+	go func() { <-o }()
+	a.WriteByte(aciaData, 0x42)
+	assert.True(t, irq.Asserted())
+
+	// Transmit break does not interrupt
+	a.ReadByte(aciaStatus)
+	a.WriteByte(aciaCommand, 0x0D)
+	assert.False(t, irq.Asserted())
+
+	// Without DTR there are no interrupts
+	a.WriteByte(aciaCommand, 0x04)
+	assert.False(t, irq.Asserted())
+}
+
+func TestAciaInterruptDriven(t *testing.T) {
+	// * 64kB RAM at 0x0000-FFFF
+	// * ACIA at 0x8800-8803, shadowing Ram
+	cpu, bus, _ := NewRamMachine()
+	acia, _ := AciaSubject()
+	bus.AttachOverlay(acia, 0x8800)
+	acia.AttachIrq(cpu.Irq)
+
+	// IRQ handler at 0x0300 stores received data in 0x0400
+	bus.Write16(IrqVector, 0x0300)
+	handler := []byte{
+		0xAD, 0x01, 0x88, // LDA AciaStatus (Acknowledge)
+		0xAD, 0x00, 0x88, // LDA AciaData
+		0x8D, 0x00, 0x04, // STA $0400
+		0x40, // RTI
+	}
+	program := []byte{
+		0xA9, 0x09, // LDA #$09
+		0x8D, 0x02, 0x88, // STA AciaCommand (RX Irq enabled)
+		0x58,             // CLI
+		0x4C, 0x06, 0x02, // JMP *
+	}
+	cpu.LoadProgram(handler, 0x0300)
+	cpu.LoadProgram(program, 0x0200)
+
+	_, err := cpu.RunCycles(20)
+	assert.Nil(t, err)
+
+	acia.Write([]byte{0x42})
+	_, err = cpu.RunCycles(40)
+	assert.Nil(t, err)
+
+	assert.EqualValues(t, 0x42, bus.ReadByte(0x0400))
+	assert.False(t, cpu.Irq.Asserted())
+	assert.EqualValues(t, 0x0206, cpu.PC)
+}
+
 func TestAciaIntegration(t *testing.T) {
 	var value []byte
 
@@ -186,3 +306,246 @@ func TestAciaIntegration(t *testing.T) {
 	assert.EqualValues(t, 0x42, value[0])
 	assert.EqualValues(t, 0xAB, cpu.A)
 }
+
+func TestAciaPeek(t *testing.T) {
+	a, _ := AciaSubject()
+	assert.Implements(t, (*Peeker)(nil), a)
+
+	a.Write([]byte{0x42, 0x43})
+	a.overrun = true
+
+	assert.EqualValues(t, 0x42, a.Peek(aciaData))
+	assert.EqualValues(t, 0x0C, a.Peek(aciaStatus)&0x0C)
+
+	// Peeking does not clear rxFull or overrun, or receive the next byte
+	assert.EqualValues(t, 0x42, a.Peek(aciaData))
+	assert.EqualValues(t, 0x0C, a.Peek(aciaStatus)&0x0C)
+
+	assert.EqualValues(t, 0x42, a.ReadByte(aciaData))
+	assert.EqualValues(t, 0x08, a.Peek(aciaStatus)&0x0C)
+	assert.EqualValues(t, 0x43, a.ReadByte(aciaData))
+	assert.EqualValues(t, 0x00, a.Peek(aciaStatus)&0x0C)
+}
+
+func TestAciaPoke(t *testing.T) {
+	a, _ := NewAcia6551(nil)
+	assert.Implements(t, (*Poker)(nil), a)
+	irq, _ := NewIrqLine()
+	a.AttachIrq(irq)
+
+	// Nothing is transmitted
+	a.Poke(aciaData, 0x42)
+	assert.EqualValues(t, 0x42, a.Peek(aciaData))
+	assert.EqualValues(t, 0x10, a.Peek(aciaStatus)&0x18)
+
+	// Enabling the transmitter interrupt does not interrupt
+	a.Poke(aciaCommand, 0x05)
+	assert.EqualValues(t, 0x05, a.Peek(aciaCommand))
+	assert.False(t, irq.Asserted())
+
+	a.Poke(aciaControl, 0x1E)
+	assert.EqualValues(t, 0x1E, a.Peek(aciaControl))
+
+	// No programmed reset
+	a.Poke(aciaStatus, 0x00)
+	assert.EqualValues(t, 0x05, a.Peek(aciaCommand))
+
+	a.Close()
+	_, err := a.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+// Lets the given number of cycles pass
+func tickAcia(a *Acia6551, cycles int) {
+	for ; cycles > 0xFF; cycles -= 0xFF {
+		a.Tick(0xFF)
+	}
+	a.Tick(uint8(cycles))
+}
+
+func TestAciaTxTiming(t *testing.T) {
+	output := make(chan []byte, 2)
+	a, _ := NewAcia6551(output)
+
+	// 9600 baud, 8 data bits, 1 stop bit: 10 bits take 1041 cycles at 1MHz
+	a.WriteByte(aciaControl, 0x1E)
+	a.WriteByte(aciaData, 0x42)
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x10)
+
+	tickAcia(a, 1040)
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x10)
+	assert.Len(t, output, 0)
+
+	tickAcia(a, 1)
+	assert.EqualValues(t, 0x10, a.ReadByte(aciaStatus)&0x10)
+	assert.Equal(t, []byte{0x42}, <-output)
+
+	// 7 data bits, 2 stop bits, parity at 2MHz
+	a.SetCpuClock(2000000)
+	a.WriteByte(aciaControl, 0xAE)
+	a.WriteByte(aciaCommand, 0x20)
+	a.WriteByte(aciaData, 0xFF)
+
+	tickAcia(a, 2290)
+	assert.Len(t, output, 0)
+	tickAcia(a, 1)
+	assert.Equal(t, []byte{0x7F}, <-output)
+}
+
+func TestAciaRxTiming(t *testing.T) {
+	a, _ := AciaSubject()
+	a.WriteByte(aciaControl, 0x1E)
+
+	a.Write([]byte{0x42, 0x43})
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x08)
+
+	tickAcia(a, 1041)
+	assert.EqualValues(t, 0x08, a.ReadByte(aciaStatus)&0x08)
+	assert.EqualValues(t, 0x42, a.ReadByte(aciaData))
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x08)
+
+	// Not reading in time overruns
+	tickAcia(a, 1041)
+	a.Write([]byte{0x44})
+	tickAcia(a, 1041)
+	assert.EqualValues(t, 0x0C, a.ReadByte(aciaStatus)&0x0C)
+	assert.EqualValues(t, 0x44, a.ReadByte(aciaData))
+}
+
+func TestAciaReadWriteConcurrent(t *testing.T) {
+	a, _ := NewAcia6551(nil)
+	a.WriteByte(aciaControl, 0x1F) // 19200 baud
+	done := make(chan []byte)
+
+	go func() {
+		data, _ := ioutil.ReadAll(a)
+		done <- data
+	}()
+
+	go func() {
+		a.Write([]byte("Hello"))
+	}()
+
+	// The Cpu echoes everything it receives, when the transmitter is empty
+	received := 0
+	for received < 5 || a.ReadByte(aciaStatus)&0x10 == 0 {
+		if a.ReadByte(aciaStatus)&0x18 == 0x18 {
+			a.WriteByte(aciaData, a.ReadByte(aciaData))
+			received++
+		}
+		a.Tick(0x10)
+	}
+
+	a.Close()
+	assert.Equal(t, []byte("Hello"), <-done)
+
+	_, err := a.Write([]byte{0x42})
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
+
+func TestAciaCloseStopsOutput(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	output := make(chan []byte)
+	a, _ := NewAcia6551(output)
+
+	// Nobody drains the output channel
+	a.WriteByte(aciaData, 0x42)
+	a.Close()
+
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, runtime.NumGoroutine() <= goroutines)
+
+	// The channel belongs to the caller, and is left open
+	select {
+	case _, ok := <-output:
+		assert.True(t, ok)
+	default:
+	}
+}
+
+func TestAciaTxBufferFull(t *testing.T) {
+	a, _ := NewAcia6551(nil)
+
+	// Nobody reads, but the Cpu does not block
+	for i := 0; i < aciaBufferSize+10; i++ {
+		a.WriteByte(aciaData, byte(i))
+	}
+	assert.True(t, a.txEmpty)
+	assert.Len(t, a.txBuffer, aciaBufferSize)
+
+	// With flow control, the transmitter waits for room
+	a.SetFlowControl(true)
+	a.WriteByte(aciaData, 0x42)
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x10)
+
+	a.Read(make([]byte, aciaBufferSize))
+	assert.EqualValues(t, 0x10, a.ReadByte(aciaStatus)&0x10)
+
+	value := make([]byte, 2)
+	n, _ := a.Read(value)
+	assert.Equal(t, []byte{0x42}, value[:n])
+}
+
+func TestAciaFlowControl(t *testing.T) {
+	a, _ := NewAcia6551(nil)
+	a.SetFlowControl(true)
+
+	// RTS is not asserted after reset
+	assert.False(t, a.RTS())
+	a.Write([]byte{0x42})
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x08)
+
+	a.WriteByte(aciaCommand, 0x08)
+	assert.True(t, a.RTS())
+	assert.EqualValues(t, 0x08, a.ReadByte(aciaStatus)&0x08)
+	assert.EqualValues(t, 0x42, a.ReadByte(aciaData))
+
+	// The host stops the transmitter with CTS
+	a.SetCTS(false)
+	a.WriteByte(aciaData, 0x43)
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x10)
+	assert.Len(t, a.txBuffer, 0)
+
+	a.SetCTS(true)
+	assert.EqualValues(t, 0x10, a.ReadByte(aciaStatus)&0x10)
+	assert.Equal(t, []byte{0x43}, a.txBuffer)
+
+	// At 9600 baud, the host holds data while RTS is deasserted, then
+	// sends a byte every 1041 cycles
+	a.WriteByte(aciaControl, 0x1E)
+	a.WriteByte(aciaCommand, 0x00)
+	a.Write([]byte("abc"))
+	tickAcia(a, 25000)
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0x08)
+
+	a.WriteByte(aciaCommand, 0x08)
+	for _, b := range []byte("abc") {
+		tickAcia(a, 1041)
+		assert.EqualValues(t, 0x08, a.ReadByte(aciaStatus)&0x0C)
+		assert.EqualValues(t, b, a.ReadByte(aciaData))
+	}
+}
+
+func TestAciaModemLines(t *testing.T) {
+	a, _ := AciaSubject()
+	irq, _ := NewIrqLine()
+	a.AttachIrq(irq)
+
+	assert.False(t, a.DTR())
+	a.WriteByte(aciaCommand, 0x01)
+	assert.True(t, a.DTR())
+
+	a.SetDCD(false)
+	assert.EqualValues(t, 0xA0, a.ReadByte(aciaStatus)&0xE0)
+	assert.False(t, irq.Asserted())
+
+	a.SetDSR(false)
+	assert.EqualValues(t, 0xE0, a.ReadByte(aciaStatus)&0xE0)
+
+	a.SetDCD(true)
+	a.SetDSR(true)
+	assert.EqualValues(t, 0x80, a.ReadByte(aciaStatus)&0xE0)
+	assert.EqualValues(t, 0x00, a.ReadByte(aciaStatus)&0xE0)
+}
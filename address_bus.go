@@ -9,24 +9,136 @@ The AddressBus contains a list of all attached memory components,
 like Ram, Rom and IO. It takes care of mapping the global 16-bit
 address space of the Cpu to the relative memory addressing of
 each component.
+
+Memory attached with Attach may not overlap. Overlays, attached with
+AttachOverlay, shadow the memory below them. Components can be swapped
+at any time with Replace and Detach.
+
+A lookup table with an entry for every address is rebuilt whenever
+memory is attached, so decoding an address takes constant time, no
+matter how many components are attached.
+
+What happens when unmapped addresses are accessed is determined by the
+UnmappedPolicy, see SetUnmappedPolicy.
 */
 type AddressBus struct {
 	addressables []*addressable // Different components
+	decoder      []*addressable // Component for each of the 64kB addresses
+	clocked      []Clocked      // Components keeping time with the Cpu
+
+	fault *BusFaultError // First fault since the last call to Fault
+
+	unmapped             UnmappedPolicy // What to do when accessing unmapped addresses
+	unmappedValue        byte           // Value read with UnmappedFixed
+	ignoreUnmappedWrites bool           // Silently drop writes to unmapped addresses
+	dataBus              byte           // Last byte transferred over the bus
 }
 
+// UnmappedPolicy determines how the AddressBus handles access to
+// addresses without any Memory attached.
+type UnmappedPolicy uint8
+
+const (
+	// Reads return 0x00, writes are ignored, and a BusFaultError is
+	// recorded. This is the default.
+	UnmappedError UnmappedPolicy = iota
+
+	// Panic with a BusFaultError.
+	UnmappedPanic
+
+	// Reads return a fixed value, see SetUnmappedValue.
+	UnmappedFixed
+
+	// Reads return the last byte transferred over the bus, like the
+	// floating data bus of real hardware.
+	UnmappedOpenBus
+)
+
 type addressable struct {
-	memory Memory // Actual memory
-	start  uint16 // First address in address space
-	end    uint16 // Last address in address space
+	memory   Memory // Actual memory
+	start    uint16 // First address in address space
+	end      uint16 // Last address in address space
+	readOnly bool   // Writes are reported as faults
+	overlay  bool   // Shadows other memory
+	mirrored bool   // Memory repeats to fill start-end
+}
+
+func newAddressable(memory Memory, offset uint16, overlay bool) (*addressable, error) {
+	start := offset
+	end := offset + memory.Size() - 1
+
+	if end < start {
+		return nil, fmt.Errorf("Memory of 0x%04X bytes does not fit at 0x%04X", memory.Size(), offset)
+	}
+
+	addressable := &addressable{memory: memory, start: start, end: end, overlay: overlay}
+
+	if readOnly, ok := memory.(ReadOnly); ok {
+		addressable.readOnly = readOnly.ReadOnly()
+	}
+
+	return addressable, nil
+}
+
+func newMirroredAddressable(memory Memory, start uint16, end uint16) (*addressable, error) {
+	size := int(memory.Size())
+	if size == 0 {
+		size = 0x10000
+	}
+
+	if end < start || int(end)-int(start)+1 < size {
+		return nil, fmt.Errorf("Memory of 0x%04X bytes does not fit in 0x%04X-%04X", size, start, end)
+	}
+
+	addressable, err := newAddressable(memory, start, false)
+	if err != nil {
+		return nil, err
+	}
+
+	addressable.end = end
+	addressable.mirrored = true
+
+	return addressable, nil
+}
+
+// Returns a new addressable for memory, at the same place and in the same mode.
+func (a *addressable) replace(memory Memory) (*addressable, error) {
+	if a.mirrored {
+		return newMirroredAddressable(memory, a.start, a.end)
+	}
+
+	return newAddressable(memory, a.start, a.overlay)
+}
+
+// Returns the address relative to the start of the memory. Mirrored
+// memory repeats every Size bytes.
+func (a *addressable) relative(address uint16) uint16 {
+	offset := address - a.start
+
+	if a.mirrored {
+		if size := a.memory.Size(); size != 0 {
+			offset %= size
+		}
+	}
+
+	return offset
 }
 
 func (a *addressable) String() string {
+	if a.overlay {
+		return fmt.Sprintf("\t0x%04X-%04X (overlay)\n", a.start, a.end)
+	}
+
 	return fmt.Sprintf("\t0x%04X-%04X\n", a.start, a.end)
 }
 
+func (a *addressable) overlaps(other *addressable) bool {
+	return a.start <= other.end && other.start <= a.end
+}
+
 // Creates a new, empty 16-bit AddressBus
 func NewAddressBus() (*AddressBus, error) {
-	return &AddressBus{addressables: make([]*addressable, 0)}, nil
+	return &AddressBus{addressables: make([]*addressable, 0), decoder: make([]*addressable, 0x10000)}, nil
 }
 
 // Returns a string with details about the AddressBus and attached memory
@@ -48,27 +160,189 @@ address 0xC000, the Size of the Memory determines the end-address.
 
     rom, _ := i6502.NewRom(0x4000)
     bus.Attach(rom, 0xC000)
+
+An error is returned if the Memory overlaps memory that is already
+attached, or does not fit in the 16-bit address space.
 */
-func (a *AddressBus) Attach(memory Memory, offset uint16) {
-	start := offset
-	end := offset + memory.Size() - 1
-	addressable := addressable{memory: memory, start: start, end: end}
+func (a *AddressBus) Attach(memory Memory, offset uint16) error {
+	addressable, err := newAddressable(memory, offset, false)
+	if err != nil {
+		return err
+	}
+
+	if err := a.checkOverlap(addressable, nil); err != nil {
+		return err
+	}
+
+	a.addressables = append(a.addressables, addressable)
+	a.rebuildDecoder()
+
+	return nil
+}
+
+/*
+Attach the given Memory so it repeats over the entire range start-end,
+emulating incomplete address decoding.
+
+For example, an Acia6551 has only 4 registers, but appears 64 times in
+0x8800-88FF when only the lowest 2 address lines are decoded:
+
+    bus.AttachMirrored(acia, 0x8800, 0x88FF)
+
+Like Attach, an error is returned if the range overlaps attached memory.
+*/
+func (a *AddressBus) AttachMirrored(memory Memory, start uint16, end uint16) error {
+	addressable, err := newMirroredAddressable(memory, start, end)
+	if err != nil {
+		return err
+	}
+
+	if err := a.checkOverlap(addressable, nil); err != nil {
+		return err
+	}
+
+	a.addressables = append(a.addressables, addressable)
+	a.rebuildDecoder()
+
+	return nil
+}
+
+/*
+Attach the given Memory as an overlay at the specified memory offset.
+
+Overlays shadow any memory attached at the same addresses, like a
+cartridge Rom on top of Ram. When overlays overlap each other, the one
+attached last wins. Detach the overlay to uncover the memory below.
+*/
+func (a *AddressBus) AttachOverlay(memory Memory, offset uint16) error {
+	addressable, err := newAddressable(memory, offset, true)
+	if err != nil {
+		return err
+	}
+
+	a.addressables = append(a.addressables, addressable)
+	a.rebuildDecoder()
+
+	return nil
+}
+
+// Detach the given Memory from the AddressBus. Returns an error if
+// the Memory was not attached.
+func (a *AddressBus) Detach(memory Memory) error {
+	remaining := make([]*addressable, 0, len(a.addressables))
+
+	for _, addressable := range a.addressables {
+		if addressable.memory != memory {
+			remaining = append(remaining, addressable)
+		}
+	}
+
+	if len(remaining) == len(a.addressables) {
+		return fmt.Errorf("Memory is not attached to the AddressBus")
+	}
+
+	a.addressables = remaining
+	a.rebuildDecoder()
+
+	return nil
+}
+
+/*
+Replace attached Memory with new Memory, at the same offset and in the
+same mode. This allows hot-swapping Rom images or cartridges.
+
+An error is returned, and nothing is changed, if the old Memory was not
+attached or the new Memory does not fit in its place.
+*/
+func (a *AddressBus) Replace(old Memory, memory Memory) error {
+	replacements := make(map[int]*addressable)
+
+	for i, addressable := range a.addressables {
+		if addressable.memory != old {
+			continue
+		}
+
+		replacement, err := addressable.replace(memory)
+		if err != nil {
+			return err
+		}
+
+		if !replacement.overlay {
+			if err := a.checkOverlap(replacement, old); err != nil {
+				return err
+			}
+		}
 
-	a.addressables = append(a.addressables, &addressable)
+		replacements[i] = replacement
+	}
+
+	if len(replacements) == 0 {
+		return fmt.Errorf("Memory is not attached to the AddressBus")
+	}
+
+	for i, replacement := range replacements {
+		a.addressables[i] = replacement
+	}
+	a.rebuildDecoder()
+
+	return nil
+}
+
+/*
+Set the policy for access to unmapped addresses:
+
+    UnmappedError   - reads return 0x00 and record a BusFaultError (default)
+    UnmappedPanic   - panic with a BusFaultError
+    UnmappedFixed   - reads return the value set with SetUnmappedValue
+    UnmappedOpenBus - reads return the last byte transferred over the bus
+
+Writes to unmapped addresses are ignored. They panic with UnmappedPanic
+and record a BusFaultError otherwise, unless IgnoreUnmappedWrites is set.
+*/
+func (a *AddressBus) SetUnmappedPolicy(policy UnmappedPolicy) {
+	a.unmapped = policy
+}
+
+// Returns the current UnmappedPolicy
+func (a *AddressBus) UnmappedPolicy() UnmappedPolicy {
+	return a.unmapped
+}
+
+// Set the value returned when reading unmapped addresses with UnmappedFixed.
+func (a *AddressBus) SetUnmappedValue(value byte) {
+	a.unmappedValue = value
+}
+
+// Silently drop writes to unmapped addresses, instead of reporting them
+// according to the UnmappedPolicy.
+func (a *AddressBus) IgnoreUnmappedWrites(ignore bool) {
+	a.ignoreUnmappedWrites = ignore
+}
+
+// Let the given number of clock cycles pass for all attached Clocked
+// memory. The Cpu calls this after every Step.
+func (a *AddressBus) Tick(cycles uint8) {
+	for _, clocked := range a.clocked {
+		clocked.Tick(cycles)
+	}
 }
 
 /*
 Read an 8-bit value from Memory attached at the 16-bit address.
 
-This will panic if you try to read from an address that has no Memory attached.
+Reading from an address that has no Memory attached is handled
+according to the UnmappedPolicy. By default it returns 0x00 and
+records a BusFaultError, see Fault.
 */
 func (a *AddressBus) ReadByte(address uint16) byte {
 	addressable, err := a.addressableForAddress(address)
 	if err != nil {
-		panic(err)
+		return a.readUnmapped(address, err)
 	}
 
-	return addressable.memory.ReadByte(address - addressable.start)
+	a.dataBus = addressable.memory.ReadByte(addressable.relative(address))
+
+	return a.dataBus
 }
 
 /*
@@ -86,16 +360,77 @@ func (a *AddressBus) Read16(address uint16) uint16 {
 /*
 Write an 8-bit value to the Memory at the 16-bit address.
 
-This will panic if you try to write to an address that has no Memory attached or
-Memory that is read-only, like Rom.
+Writing to Memory that is read-only, like Rom, is ignored and records a
+BusFaultError, see Fault. Writing to an address that has no Memory
+attached is handled according to the UnmappedPolicy.
 */
 func (a *AddressBus) WriteByte(address uint16, data byte) {
+	a.dataBus = data
+
 	addressable, err := a.addressableForAddress(address)
 	if err != nil {
-		panic(err)
+		a.writeUnmapped(address, err)
+		return
+	}
+
+	if addressable.readOnly {
+		a.recordFault(address, true, fmt.Sprintf("Trying to write to read-only memory at 0x%04X", address))
+		return
 	}
 
-	addressable.memory.WriteByte(address-addressable.start, data)
+	addressable.memory.WriteByte(addressable.relative(address), data)
+}
+
+/*
+Read an 8-bit value at the 16-bit address without side effects, for
+debuggers and memory dumps. Memory implementing Peeker, like the
+Acia6551, is read with Peek.
+
+Unmapped addresses return 0x00, or the value the UnmappedPolicy
+would return. No faults are recorded.
+*/
+func (a *AddressBus) Peek(address uint16) byte {
+	addressable, err := a.addressableForAddress(address)
+	if err != nil {
+		switch a.unmapped {
+		case UnmappedFixed:
+			return a.unmappedValue
+		case UnmappedOpenBus:
+			return a.dataBus
+		}
+
+		return 0x00
+	}
+
+	return peek(addressable.memory, addressable.relative(address))
+}
+
+/*
+Write an 8-bit value at the 16-bit address for debuggers. Memory
+implementing Poker, like Rom, is written with Poke, so Rom can be
+patched and I/O registers set without side effects. Other Memory is
+written with WriteByte. Writes to unmapped addresses are ignored. No
+faults are recorded.
+*/
+func (a *AddressBus) Poke(address uint16, data byte) {
+	addressable, err := a.addressableForAddress(address)
+	if err != nil {
+		return
+	}
+
+	poke(addressable.memory, addressable.relative(address), data)
+}
+
+// Writes a byte of a program file. Like Poke, Rom is written as well, but
+// writes to unmapped addresses are handled like WriteByte.
+func (a *AddressBus) load(address uint16, data byte) {
+	addressable, err := a.addressableForAddress(address)
+	if err != nil {
+		a.writeUnmapped(address, err)
+		return
+	}
+
+	poke(addressable.memory, addressable.relative(address), data)
 }
 
 /*
@@ -108,13 +443,122 @@ func (a *AddressBus) Write16(address uint16, data uint16) {
 	a.WriteByte(address+1, byte(data>>8))
 }
 
+/*
+Returns the first fault since the last call to Fault, or nil if there
+was none. The fault is cleared.
+
+The Cpu checks for faults after every instruction, and returns them
+from Step.
+*/
+func (a *AddressBus) Fault() error {
+	if fault := a.takeFault(); fault != nil {
+		return fault
+	}
+
+	return nil
+}
+
+func (a *AddressBus) takeFault() *BusFaultError {
+	fault := a.fault
+	a.fault = nil
+
+	return fault
+}
+
+// Records a fault, unless an earlier fault has not been taken yet.
+func (a *AddressBus) recordFault(address uint16, write bool, reason string) {
+	if a.fault == nil {
+		a.fault = &BusFaultError{Address: address, Write: write, Reason: reason}
+	}
+}
+
+func (a *AddressBus) readUnmapped(address uint16, err error) byte {
+	switch a.unmapped {
+	case UnmappedPanic:
+		panic(&BusFaultError{Address: address, Reason: err.Error()})
+	case UnmappedFixed:
+		return a.unmappedValue
+	case UnmappedOpenBus:
+		return a.dataBus
+	}
+
+	a.recordFault(address, false, err.Error())
+
+	return 0x00
+}
+
+func (a *AddressBus) writeUnmapped(address uint16, err error) {
+	if a.ignoreUnmappedWrites {
+		return
+	}
+
+	if a.unmapped == UnmappedPanic {
+		panic(&BusFaultError{Address: address, Write: true, Reason: err.Error()})
+	}
+
+	a.recordFault(address, true, err.Error())
+}
+
 // Returns the addressable for the specified address, or an error if no addressable exists.
 func (a *AddressBus) addressableForAddress(address uint16) (*addressable, error) {
+	if addressable := a.decoder[address]; addressable != nil {
+		return addressable, nil
+	}
+
+	return nil, fmt.Errorf("No addressable memory found at 0x%04X", address)
+}
+
+// Returns an error if addressable overlaps attached memory, other than
+// overlays and the ignored Memory.
+func (a *AddressBus) checkOverlap(addressable *addressable, ignore Memory) error {
+	for _, other := range a.addressables {
+		if other.overlay || other.memory == ignore {
+			continue
+		}
+
+		if addressable.overlaps(other) {
+			return fmt.Errorf("Memory at 0x%04X-%04X overlaps memory at 0x%04X-%04X", addressable.start, addressable.end, other.start, other.end)
+		}
+	}
+
+	return nil
+}
+
+// Fill the decoder with the addressable for each address. Overlays are
+// filled in last, in the order they were attached, to shadow other memory.
+// Also collects the Clocked memory.
+func (a *AddressBus) rebuildDecoder() {
+	for i := range a.decoder {
+		a.decoder[i] = nil
+	}
+
+	a.clocked = nil
 	for _, addressable := range a.addressables {
-		if addressable.start <= address && addressable.end >= address {
-			return addressable, nil
+		if clocked, ok := addressable.memory.(Clocked); ok && !a.isClocked(clocked) {
+			a.clocked = append(a.clocked, clocked)
 		}
 	}
 
-	return nil, fmt.Errorf("No addressable memory found at 0x%04X", address)
+	for _, overlay := range []bool{false, true} {
+		for _, addressable := range a.addressables {
+			if addressable.overlay != overlay {
+				continue
+			}
+
+			for address := int(addressable.start); address <= int(addressable.end); address++ {
+				a.decoder[address] = addressable
+			}
+		}
+	}
+}
+
+// Returns true if clocked is already ticked by the AddressBus
+func (a *AddressBus) isClocked(clocked Clocked) bool {
+	for _, other := range a.clocked {
+		if other == clocked {
+			return true
+		}
+	}
+
+	return false
 }
@@ -64,3 +64,322 @@ func TestBusReadWrite(t *testing.T) {
 	bus.WriteByte(0x8001, 0x12)
 	assert.EqualValues(0x12, ram2.ReadByte(0x0001))
 }
+
+func TestBusUnmappedFault(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0x8000)
+	bus.Attach(ram, 0x0000)
+
+	assert.Nil(bus.Fault())
+
+	assert.EqualValues(0x00, bus.ReadByte(0x9000))
+	bus.WriteByte(0x9001, 0x42)
+
+	// Only the first fault is kept
+	fault, ok := bus.Fault().(*BusFaultError)
+	if assert.True(ok) {
+		assert.EqualValues(0x9000, fault.Address)
+		assert.False(fault.Write)
+	}
+
+	// Taking the fault clears it
+	assert.Nil(bus.Fault())
+
+	bus.WriteByte(0x9001, 0x42)
+	fault, ok = bus.Fault().(*BusFaultError)
+	if assert.True(ok) {
+		assert.EqualValues(0x9001, fault.Address)
+		assert.True(fault.Write)
+	}
+}
+
+func TestBusRomWriteFault(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	rom, _ := NewRom("test/8kb.rom")
+	bus.Attach(rom, 0xE000)
+
+	assert.NotPanics(func() {
+		bus.WriteByte(0xE000, 0x42)
+	})
+	assert.EqualValues(0x01, bus.ReadByte(0xE000))
+
+	fault, ok := bus.Fault().(*BusFaultError)
+	if assert.True(ok) {
+		assert.EqualValues(0xE000, fault.Address)
+		assert.True(fault.Write)
+		assert.Contains(fault.Error(), "read-only")
+	}
+}
+
+func TestBusOverlappingMemory(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0x8000)
+	ram2, _ := NewRam(0x8000)
+	ram3, _ := NewRam(0x1000)
+
+	assert.Nil(bus.Attach(ram, 0x0000))
+	assert.NotNil(bus.Attach(ram2, 0x4000))
+	assert.NotNil(bus.Attach(ram3, 0x7001))
+	assert.EqualValues(1, len(bus.addressables))
+
+	// Adjacent is fine
+	assert.Nil(bus.Attach(ram2, 0x8000))
+
+	bus.WriteByte(0x4000, 0x42)
+	assert.EqualValues(0x42, ram.ReadByte(0x4000))
+	assert.EqualValues(0x00, ram2.ReadByte(0x0000))
+}
+
+func TestBusAttachDoesNotFit(t *testing.T) {
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0x2000)
+
+	assert.NotNil(t, bus.Attach(ram, 0xF000))
+	assert.NotNil(t, bus.AttachOverlay(ram, 0xF000))
+	assert.EqualValues(t, 0, len(bus.addressables))
+}
+
+func TestBusOverlay(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0x10000)
+	cartridge, _ := NewRom("test/8kb.rom")
+	patch, _ := NewRam(0x0100)
+	bus.Attach(ram, 0x0000)
+	ram.WriteByte(0xA000, 0x42)
+
+	assert.Nil(bus.AttachOverlay(cartridge, 0xA000))
+	assert.Nil(bus.AttachOverlay(patch, 0xA000))
+
+	// Regular memory still can't overlap the Ram
+	other, _ := NewRam(0x0100)
+	assert.NotNil(bus.Attach(other, 0xB000))
+
+	// Latest overlay wins
+	assert.EqualValues(0x00, bus.ReadByte(0xA000))
+	assert.EqualValues(0xFF, bus.ReadByte(0xBFFF))
+
+	// Uncover the cartridge, then the Ram below
+	assert.Nil(bus.Detach(patch))
+	assert.EqualValues(0x01, bus.ReadByte(0xA000))
+
+	assert.Nil(bus.Detach(cartridge))
+	assert.EqualValues(0x42, bus.ReadByte(0xA000))
+	assert.NotNil(bus.Detach(cartridge))
+}
+
+func TestBusAttachBelowOverlay(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	cartridge, _ := NewRom("test/8kb.rom")
+	ram, _ := NewRam(0x4000)
+	assert.Nil(bus.AttachOverlay(cartridge, 0xA000))
+
+	// Overlays don't count as overlap, the overlay keeps shadowing the Ram
+	assert.Nil(bus.Attach(ram, 0x8000))
+	ram.WriteByte(0x2000, 0x42)
+	assert.EqualValues(0x01, bus.ReadByte(0xA000))
+
+	assert.Nil(bus.Detach(cartridge))
+	assert.EqualValues(0x42, bus.ReadByte(0xA000))
+}
+
+func TestBusDetach(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0x8000)
+	ram2, _ := NewRam(0x8000)
+	bus.Attach(ram, 0x0000)
+	bus.Attach(ram2, 0x8000)
+
+	assert.Nil(bus.Detach(ram2))
+	assert.EqualValues(1, len(bus.addressables))
+
+	bus.ReadByte(0x8000)
+	assert.NotNil(bus.Fault())
+
+	// The freed space can be used again
+	assert.Nil(bus.Attach(ram2, 0x8000))
+}
+
+func TestBusReplace(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0xC000)
+	rom, _ := NewRom("test/8kb.rom")
+	rom2, _ := NewRom("test/16kb.rom")
+	bus.Attach(ram, 0x0000)
+	bus.Attach(rom, 0xC000)
+
+	assert.Nil(bus.Replace(rom, rom2))
+	assert.NotNil(bus.Replace(rom, rom2))
+
+	// Keeps the original offset and read-only mode
+	assert.EqualValues(0x01, bus.ReadByte(0xC000))
+	assert.EqualValues(0xFF, bus.ReadByte(0xFFFF))
+	bus.WriteByte(0xC000, 0x42)
+	assert.NotNil(bus.Fault())
+
+	// Ram does not fit at 0xC000, nothing changes
+	assert.NotNil(bus.Replace(rom2, ram))
+	assert.EqualValues(0xFF, bus.ReadByte(0xFFFF))
+}
+
+func BenchmarkBusReadByte(b *testing.B) {
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0x8000)
+	bus.Attach(ram, 0x0000)
+	for i := 0; i < 12; i++ {
+		acia, _ := NewAcia6551(nil)
+		bus.Attach(acia, 0x8000+uint16(i)*0x10)
+	}
+	rom, _ := NewRam(0x4000)
+	bus.Attach(rom, 0xC000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.ReadByte(0xC000 + uint16(i&0x3FFF))
+	}
+}
+
+func TestBusAttachMirrored(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	acia, _ := AciaSubject()
+
+	assert.Nil(bus.AttachMirrored(acia, 0x8800, 0x88FF))
+
+	// Control register appears every 4 bytes
+	bus.WriteByte(0x8803, 0xB8)
+	assert.EqualValues(0xB8, bus.ReadByte(0x8803))
+	assert.EqualValues(0xB8, bus.ReadByte(0x8807))
+	assert.EqualValues(0xB8, bus.ReadByte(0x88FF))
+
+	bus.WriteByte(0x88F6, 0x06)
+	assert.EqualValues(0x06, acia.ReadByte(aciaCommand))
+
+	// Outside the mirrored range
+	bus.ReadByte(0x8900)
+	assert.NotNil(bus.Fault())
+}
+
+func TestBusAttachMirroredErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0x0100)
+	ram2, _ := NewRam(0x1000)
+	bus.Attach(ram, 0x8000)
+
+	assert.NotNil(bus.AttachMirrored(ram2, 0x9000, 0x90FF)) // Too small
+	assert.NotNil(bus.AttachMirrored(ram2, 0x9FFF, 0x9000)) // Reversed
+	assert.NotNil(bus.AttachMirrored(ram, 0x7000, 0x80FF))  // Overlaps
+	assert.Nil(bus.AttachMirrored(ram2, 0x9000, 0xFFFF))
+
+	// Replaced memory is mirrored over the same range
+	ram3, _ := NewRam(0x2000)
+	assert.Nil(bus.Replace(ram2, ram3))
+	bus.WriteByte(0xA001, 0x42)
+	assert.EqualValues(0x42, ram3.ReadByte(0x1001))
+	assert.EqualValues(0x42, bus.ReadByte(0xE001))
+}
+
+func TestBusUnmappedPanic(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	bus.SetUnmappedPolicy(UnmappedPanic)
+	assert.Equal(UnmappedPanic, bus.UnmappedPolicy())
+
+	assert.Panics(func() { bus.ReadByte(0x1234) })
+	assert.Panics(func() { bus.WriteByte(0x1234, 0x42) })
+
+	bus.IgnoreUnmappedWrites(true)
+	assert.NotPanics(func() { bus.WriteByte(0x1234, 0x42) })
+}
+
+func TestBusUnmappedFixed(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	bus.SetUnmappedPolicy(UnmappedFixed)
+	bus.SetUnmappedValue(0xEA)
+
+	assert.EqualValues(0xEA, bus.ReadByte(0x1234))
+	assert.Nil(bus.Fault())
+
+	// Writes are still reported, unless ignored
+	bus.WriteByte(0x1234, 0x42)
+	assert.NotNil(bus.Fault())
+
+	bus.IgnoreUnmappedWrites(true)
+	bus.WriteByte(0x1234, 0x42)
+	assert.Nil(bus.Fault())
+}
+
+func TestBusUnmappedOpenBus(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0x1000)
+	bus.Attach(ram, 0x0000)
+	bus.SetUnmappedPolicy(UnmappedOpenBus)
+	bus.IgnoreUnmappedWrites(true)
+
+	ram.WriteByte(0x0010, 0xAB)
+	bus.ReadByte(0x0010)
+	assert.EqualValues(0xAB, bus.ReadByte(0x8000))
+
+	bus.WriteByte(0x0020, 0xCD)
+	assert.EqualValues(0xCD, bus.ReadByte(0x8000))
+
+	// Writes to unmapped space still drive the data bus
+	bus.WriteByte(0x9000, 0x5A)
+	assert.EqualValues(0x5A, bus.ReadByte(0x8000))
+	assert.Nil(bus.Fault())
+}
+
+func TestBusPeekPoke(t *testing.T) {
+	assert := assert.New(t)
+
+	bus, _ := NewAddressBus()
+	ram, _ := NewRam(0x1000)
+	rom, _ := NewRom("test/8kb.rom")
+	acia, _ := AciaSubject()
+	bus.Attach(ram, 0x0000)
+	bus.Attach(rom, 0xE000)
+	bus.Attach(acia, 0x8800)
+
+	bus.Poke(0x0010, 0x42)
+	assert.EqualValues(0x42, bus.Peek(0x0010))
+
+	// Rom can be patched
+	bus.Poke(0xE000, 0x42)
+	assert.EqualValues(0x42, bus.Peek(0xE000))
+	assert.EqualValues(0x42, rom.ReadByte(0x0000))
+
+	// IO is inspected without side effects
+	acia.Write([]byte{0x55})
+	assert.EqualValues(0x55, bus.Peek(0x8800))
+	assert.EqualValues(0x08, bus.Peek(0x8801)&0x08)
+
+	// Unmapped addresses do not fault
+	assert.EqualValues(0x00, bus.Peek(0x9000))
+	bus.Poke(0x9000, 0x42)
+	assert.Nil(bus.Fault())
+
+	bus.SetUnmappedPolicy(UnmappedFixed)
+	bus.SetUnmappedValue(0xEA)
+	assert.EqualValues(0xEA, bus.Peek(0x9000))
+}
@@ -0,0 +1,128 @@
+package i6502
+
+import "fmt"
+
+/*
+BankedMemory exposes a window onto a larger backing store, made up of
+banks of equal size. Only the selected bank is visible through the window.
+
+Banks can be any Memory, like Ram and Rom. For example, 512kB of Ram
+paged into a 16kB window at 0x8000:
+
+	banks := make([]i6502.Memory, 32)
+	for i := range banks {
+	    banks[i], _ = i6502.NewRam(0x4000)
+	}
+
+	banked, err := i6502.NewBankedMemory(banks...)
+	bus.Attach(banked, 0x8000)
+
+Writes to a read-only bank, like Rom, are ignored. Use a BankSelect
+register to let the Cpu switch banks.
+*/
+type BankedMemory struct {
+	banks []Memory
+	bank  int // Selected bank
+}
+
+// Create a new BankedMemory from the given banks, which must all be of the
+// same size. The first bank is selected.
+func NewBankedMemory(banks ...Memory) (*BankedMemory, error) {
+	if len(banks) == 0 {
+		return nil, fmt.Errorf("BankedMemory needs at least one bank")
+	}
+
+	for i, bank := range banks {
+		if bank.Size() != banks[0].Size() {
+			return nil, fmt.Errorf("Bank %d is 0x%04X bytes, expected 0x%04X", i, bank.Size(), banks[0].Size())
+		}
+	}
+
+	return &BankedMemory{banks: banks}, nil
+}
+
+func (b *BankedMemory) Size() uint16 {
+	return b.banks[0].Size()
+}
+
+func (b *BankedMemory) ReadByte(address uint16) byte {
+	return b.banks[b.bank].ReadByte(address)
+}
+
+func (b *BankedMemory) WriteByte(address uint16, data byte) {
+	bank := b.banks[b.bank]
+
+	if readOnly, ok := bank.(ReadOnly); ok && readOnly.ReadOnly() {
+		return
+	}
+
+	bank.WriteByte(address, data)
+}
+
+// Peek into the selected bank, without side effects.
+func (b *BankedMemory) Peek(address uint16) byte {
+	return peek(b.banks[b.bank], address)
+}
+
+// Poke into the selected bank, even if it is read-only.
+func (b *BankedMemory) Poke(address uint16, data byte) {
+	poke(b.banks[b.bank], address, data)
+}
+
+// Returns the number of banks
+func (b *BankedMemory) Banks() int {
+	return len(b.banks)
+}
+
+// Returns the selected bank
+func (b *BankedMemory) Bank() int {
+	return b.bank
+}
+
+// Select the bank visible through the window.
+func (b *BankedMemory) SelectBank(bank int) error {
+	if bank < 0 || bank >= len(b.banks) {
+		return fmt.Errorf("Bank %d does not exist, there are %d banks", bank, len(b.banks))
+	}
+
+	b.bank = bank
+
+	return nil
+}
+
+/*
+BankSelect is a single byte latch register, selecting the bank of one or
+more BankedMemory windows. It can be attached anywhere on the AddressBus.
+
+The value written is used as the bank number, wrapping around when it
+exceeds the number of banks of a window. Reading returns the latched value.
+*/
+type BankSelect struct {
+	latch   byte
+	windows []*BankedMemory
+}
+
+// Create a new BankSelect register, controlling the given windows. The
+// latch is reset to 0x00, selecting the first bank.
+func NewBankSelect(windows ...*BankedMemory) (*BankSelect, error) {
+	s := &BankSelect{windows: windows}
+	s.WriteByte(0, 0x00)
+
+	return s, nil
+}
+
+func (s *BankSelect) Size() uint16 {
+	return 0x01
+}
+
+func (s *BankSelect) ReadByte(address uint16) byte {
+	return s.latch
+}
+
+func (s *BankSelect) WriteByte(address uint16, data byte) {
+	s.latch = data
+
+	for _, window := range s.windows {
+		window.SelectBank(int(data) % window.Banks())
+	}
+}
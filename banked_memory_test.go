@@ -0,0 +1,134 @@
+package i6502
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func BankedSubject(banks int) (*BankedMemory, []*Ram) {
+	rams := make([]*Ram, banks)
+	memories := make([]Memory, banks)
+
+	for i := range rams {
+		rams[i], _ = NewRam(0x4000)
+		memories[i] = rams[i]
+	}
+
+	banked, _ := NewBankedMemory(memories...)
+	return banked, rams
+}
+
+func TestBankedMemoryAsMemory(t *testing.T) {
+	assert.Implements(t, (*Memory)(nil), new(BankedMemory))
+	assert.Implements(t, (*Memory)(nil), new(BankSelect))
+}
+
+func TestNewBankedMemory(t *testing.T) {
+	banked, err := NewBankedMemory()
+	assert.NotNil(t, err)
+	assert.Nil(t, banked)
+
+	ram, _ := NewRam(0x4000)
+	small, _ := NewRam(0x2000)
+	banked, err = NewBankedMemory(ram, small)
+	assert.NotNil(t, err)
+	assert.Nil(t, banked)
+
+	banked, err = NewBankedMemory(ram, ram)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0x4000, banked.Size())
+	assert.EqualValues(t, 2, banked.Banks())
+	assert.EqualValues(t, 0, banked.Bank())
+}
+
+func TestBankedMemoryReadWrite(t *testing.T) {
+	banked, rams := BankedSubject(4)
+
+	banked.WriteByte(0x1234, 0x42)
+	assert.EqualValues(t, 0x42, rams[0].ReadByte(0x1234))
+
+	assert.Nil(t, banked.SelectBank(3))
+	assert.EqualValues(t, 0x00, banked.ReadByte(0x1234))
+
+	banked.WriteByte(0x1234, 0x43)
+	assert.EqualValues(t, 0x43, rams[3].ReadByte(0x1234))
+	assert.EqualValues(t, 0x42, rams[0].ReadByte(0x1234))
+
+	assert.NotNil(t, banked.SelectBank(4))
+	assert.NotNil(t, banked.SelectBank(-1))
+	assert.EqualValues(t, 3, banked.Bank())
+}
+
+func TestBankedMemoryRomBank(t *testing.T) {
+	ram, _ := NewRam(0x2000)
+	rom, _ := NewRom("test/8kb.rom")
+	banked, _ := NewBankedMemory(ram, rom)
+
+	banked.SelectBank(1)
+
+	assert.NotPanics(t, func() {
+		banked.WriteByte(0x0000, 0x42)
+	})
+	assert.EqualValues(t, 0x01, banked.ReadByte(0x0000))
+}
+
+func TestBankSelect(t *testing.T) {
+	banked, _ := BankedSubject(4)
+	banked2, _ := BankedSubject(2)
+	bankSelect, err := NewBankSelect(banked, banked2)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0x01, bankSelect.Size())
+
+	bankSelect.WriteByte(0, 0x03)
+	assert.EqualValues(t, 0x03, bankSelect.ReadByte(0))
+	assert.EqualValues(t, 3, banked.Bank())
+	assert.EqualValues(t, 1, banked2.Bank())
+
+	// Wraps around
+	bankSelect.WriteByte(0, 0x06)
+	assert.EqualValues(t, 2, banked.Bank())
+	assert.EqualValues(t, 0, banked2.Bank())
+}
+
+func TestBankedMemoryIntegration(t *testing.T) {
+	// * 32kB RAM at 0x0000-7FFF
+	// * Bank select at 0x7FFF, shadowing Ram
+	// * 512kB RAM in 16kB banks at 0x8000-BFFF
+	ram, _ := NewRam(0x8000)
+	banked, rams := BankedSubject(32)
+	bankSelect, _ := NewBankSelect(banked)
+	bus, _ := NewAddressBus()
+	bus.Attach(ram, 0x0000)
+	bus.AttachOverlay(bankSelect, 0x7FFF)
+	bus.Attach(banked, 0x8000)
+	cpu, _ := NewCpu(bus, Wdc65C02)
+
+	program := []byte{
+		0xA9, 0x1F, // LDA #$1F
+		0x8D, 0xFF, 0x7F, // STA $7FFF (Select bank 31)
+		0xA9, 0x42, // LDA #$42
+		0x8D, 0x00, 0x80, // STA $8000
+		0x9C, 0xFF, 0x7F, // STZ $7FFF (Select bank 0)
+		0xAD, 0x00, 0x80, // LDA $8000
+	}
+
+	cpu.LoadProgram(program, 0x0200)
+	assert.Nil(t, cpu.Steps(6))
+
+	assert.EqualValues(t, 0x42, rams[31].ReadByte(0x0000))
+	assert.EqualValues(t, 0x00, cpu.A)
+	assert.EqualValues(t, 0, banked.Bank())
+}
+
+func TestBankedMemoryPeekPoke(t *testing.T) {
+	ram, _ := NewRam(0x2000)
+	rom, _ := NewRom("test/8kb.rom")
+	banked, _ := NewBankedMemory(ram, rom)
+
+	banked.SelectBank(1)
+	banked.Poke(0x0000, 0x42)
+	assert.EqualValues(t, 0x42, banked.Peek(0x0000))
+	assert.EqualValues(t, 0x42, rom.ReadByte(0x0000))
+}
@@ -1,6 +1,9 @@
 package i6502
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+)
 
 /*
 The Cpu only contains the AddressBus, through which 8-bit values can be read and written
@@ -22,20 +25,84 @@ type Cpu struct {
 	SP byte   // Stack Pointer
 
 	Bus *AddressBus // The address bus
+	Irq *IrqLine    // The IRQ line, shared by interrupting devices
+
+	Cycles uint64 // Number of clock cycles executed
+
+	variant Variant          // Emulated processor
+	opTypes map[uint8]OpType // Instruction set of the variant
+	state   State            // Running, waiting or stopped
+
+	nmiPending bool // A falling edge on NMI has not been handled yet
+
+	pageCrossed bool  // Indexed addressing crossed a page boundary
+	extraCycles uint8 // Penalty cycles for the current instruction
 }
 
+// The processor variant emulated by the Cpu
+type Variant uint8
+
+const (
+	Mos6502             Variant = iota // Original NMOS 6502, documented instructions only
+	Wdc65C02                           // WDC 65C02, with additional instructions and bug fixes
+	Mos6502Undocumented                // NMOS 6502, including stable undocumented instructions
+)
+
+// The execution state of the Cpu
+type State uint8
+
+const (
+	Running State = iota // Executing instructions
+	Waiting              // Halted by WAI, until an interrupt occurs
+	Stopped              // Halted by STP, until Reset
+)
+
 const (
 	ZeropageBase = 0x0000 // 0x0000-00FF Reserved for zeropage instructions
 	StackBase    = 0x0100 // 0x0100-01FF Reserved for stack
 
+	NmiVector   = 0xFFFA // 0xFFFA-FFFB
 	ResetVector = 0xFFFC // 0xFFFC-FFFD
 	IrqVector   = 0xFFFE // 0xFFFE-FFFF
 
+	interruptCycles = 7 // Clock cycles needed to handle an interrupt
 )
 
-// Create an new Cpu, using the AddressBus for accessing memory.
-func NewCpu(bus *AddressBus) (*Cpu, error) {
-	return &Cpu{Bus: bus}, nil
+/*
+Create an new Cpu, using the AddressBus for accessing memory.
+
+By default a MOS 6502 is emulated. Optionally, specify another Variant:
+
+	cpu, err := i6502.NewCpu(bus, i6502.Wdc65C02)
+*/
+func NewCpu(bus *AddressBus, variant ...Variant) (*Cpu, error) {
+	irq, _ := NewIrqLine()
+	c := &Cpu{Bus: bus, Irq: irq, variant: Mos6502}
+
+	if len(variant) > 1 {
+		return nil, fmt.Errorf("Only one Cpu variant can be specified, got %d", len(variant))
+	}
+	if len(variant) == 1 {
+		c.variant = variant[0]
+	}
+
+	opTypes, ok := instructionSets[c.variant]
+	if !ok {
+		return nil, fmt.Errorf("Unknown Cpu variant %d", c.variant)
+	}
+	c.opTypes = opTypes
+
+	return c, nil
+}
+
+// Returns the processor Variant emulated by the Cpu.
+func (c *Cpu) Variant() Variant {
+	return c.variant
+}
+
+// Returns whether the Cpu is Running, Waiting or Stopped.
+func (c *Cpu) State() State {
+	return c.state
 }
 
 // Returns a string containing the current state of the CPU.
@@ -50,6 +117,7 @@ Reset the CPU, emulating the RESB pin.
 The status register is reset to a know state (0x34, IrqDisabled set, Decimal unset, Break set).
 
 Then the Program Counter is set to the value read from `ResetVector` (0xFFFC-FFFD).
+A Waiting or Stopped Cpu will be Running again.
 
 Normally, no assumptions can be made about registers (A, X, Y) and the
 Stack Pointer. For convenience, these are reset to 0x00 (A,X,Y) and 0xFF (SP).
@@ -57,6 +125,8 @@ Stack Pointer. For convenience, these are reset to 0x00 (A,X,Y) and 0xFF (SP).
 func (c *Cpu) Reset() {
 	c.PC = c.Bus.Read16(ResetVector)
 	c.P = 0x34
+	c.state = Running
+	c.nmiPending = false
 
 	// Not specified, but let's clean up
 	c.A = 0x00
@@ -66,54 +136,278 @@ func (c *Cpu) Reset() {
 }
 
 /*
-Simulate the IRQ pin.
+Simulate a pulse on the IRQ pin.
 
 This will push the current Cpu state to the stack (P + PC) and set the PC
-to the address read from the `IrqVector` (0xFFFE-FFFF)
+to the address read from the `IrqVector` (0xFFFE-FFFF).
+
+The interrupt is ignored when the IrqDisable flag is set. A Cpu Waiting
+because of WAI will resume nonetheless. A Stopped Cpu ignores the interrupt.
+
+Devices that hold the IRQ line until they're serviced should use the
+level-triggered `Irq` line instead.
+
+Like Step, the cycles used are passed on to Clocked memory.
 */
 func (c *Cpu) Interrupt() {
-	c.handleIrq(c.PC)
+	if c.state == Stopped {
+		return
+	}
+
+	if c.getIrqDisable() {
+		c.state = Running
+		return
+	}
+
+	c.Bus.Tick(c.serviceInterrupt(IrqVector))
 }
 
-// Handles an interrupt or BRK.
+/*
+Simulate the NMI pin, triggered by a falling edge.
+
+The NMI is handled before the next instruction is executed, regardless of
+the IrqDisable flag. Like an IRQ, the Cpu state is pushed to the stack, but
+the PC is set to the address read from the `NmiVector` (0xFFFA-FFFB).
+
+Triggering NMI again before it is handled has no additional effect. A
+Stopped Cpu ignores the NMI.
+*/
+func (c *Cpu) NMI() {
+	if c.state == Stopped {
+		return
+	}
+
+	c.nmiPending = true
+}
+
+// Handles a hardware interrupt through the given vector, waking up a
+// Waiting Cpu. The Break flag is pushed cleared, so handlers can tell
+// an interrupt from BRK. Returns the number of cycles used.
+func (c *Cpu) serviceInterrupt(vector uint16) uint8 {
+	c.state = Running
+	c.handleInterrupt(c.PC, c.P&^0x10|0x20, vector)
+	c.Cycles += interruptCycles
+
+	return interruptCycles
+}
+
+// Handles BRK, pushing the status with the Break flag set.
 func (c *Cpu) handleIrq(PC uint16) {
+	c.handleInterrupt(PC, c.P|0x30, IrqVector)
+}
+
+// Pushes PC and status to the stack and continues at the address in vector.
+func (c *Cpu) handleInterrupt(PC uint16, status byte, vector uint16) {
 	c.stackPush(byte(PC >> 8))
 	c.stackPush(byte(PC))
-	c.stackPush(c.P)
+	c.stackPush(status)
 
 	c.setIrqDisable(true)
 
-	c.PC = c.Bus.Read16(IrqVector)
+	// The 65C02 leaves decimal mode when handling interrupts
+	if c.variant == Wdc65C02 {
+		c.setDecimal(false)
+	}
+
+	c.PC = c.Bus.Read16(vector)
 }
 
 // Load the specified program data at the given memory location
 // and point the Program Counter to the beginning of the program.
-func (c *Cpu) LoadProgram(data []byte, location uint16) {
+// Returns the first BusFaultError if the program does not fit.
+func (c *Cpu) LoadProgram(data []byte, location uint16) error {
 	for i, b := range data {
 		c.Bus.WriteByte(location+uint16(i), b)
 	}
 
 	c.PC = location
+
+	return c.Bus.Fault()
+}
+
+// Load an Intel HEX file, see LoadIntelHex. If the file contains a start
+// address, the Program Counter is pointed to it.
+func (c *Cpu) LoadIntelHex(r io.Reader) error {
+	return c.loadFile(LoadIntelHex(r, c.Bus))
 }
 
-func (c *Cpu) Steps(steps int) {
-	for i := 0; i < steps; i++ {
-		c.Step()
+// Load a Motorola S-record file, see LoadSRecord. If the file contains a
+// start address, the Program Counter is pointed to it.
+func (c *Cpu) LoadSRecord(r io.Reader) error {
+	return c.loadFile(LoadSRecord(r, c.Bus))
+}
+
+// Load a PRG file at its load address, and point the Program Counter to it.
+func (c *Cpu) LoadPrg(prg *Prg) error {
+	return c.LoadProgram(prg.Data, prg.LoadAddress)
+}
+
+/*
+Relocate an o65 object to base, with the data and bss segments right after
+the text segment, and load it. The Program Counter points to the start of
+the text segment.
+*/
+func (c *Cpu) LoadO65(o *O65, base uint16) error {
+	data := base + o.TextLen
+	if err := o.Relocate(base, data, data+o.DataLen, o.ZeroBase); err != nil {
+		return err
+	}
+
+	if o.Mode&o65ModeBssZero != 0 {
+		if err := c.LoadProgram(make([]byte, o.BssLen), o.BssBase); err != nil {
+			return err
+		}
+	}
+
+	if err := c.LoadProgram(o.Data, o.DataBase); err != nil {
+		return err
+	}
+
+	return c.LoadProgram(o.Text, o.TextBase)
+}
+
+func (c *Cpu) loadFile(program *Program, err error) error {
+	if err != nil {
+		c.Bus.takeFault()
+		return err
+	}
+
+	if program.HasStart {
+		c.PC = program.Start
+	}
+
+	return c.Bus.Fault()
+}
+
+// Execute the given number of steps, stopping at the first error.
+func (c *Cpu) Steps(steps int) error {
+	for i := 0; i < steps && c.state != Stopped; i++ {
+		if _, err := c.Step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+Execute instructions until at least the given number of clock cycles
+have passed.
+
+Instructions are never interrupted halfway, so the last instruction may
+overshoot the budget. The number of cycles actually executed is returned,
+which is less than the budget when the Cpu is Stopped or Step returns an
+error.
+*/
+func (c *Cpu) RunCycles(cycles uint64) (uint64, error) {
+	var executed uint64
+
+	for executed < cycles && c.state != Stopped {
+		stepped, err := c.Step()
+		executed += uint64(stepped)
+
+		if err != nil {
+			return executed, err
+		}
 	}
+
+	return executed, nil
 }
 
-// Read and execute the instruction pointed to by the Program Counter (PC)
-func (c *Cpu) Step() {
-	instruction := c.readNextInstruction()
+/*
+Read and execute the instruction pointed to by the Program Counter (PC).
+Returns the number of clock cycles the instruction took.
+
+An UnknownOpcodeError is returned when the opcode is not supported by the
+Cpu variant, leaving the PC pointing at the opcode. A BusFaultError is
+returned when the AddressBus reported a fault. When the fault happens while
+fetching the instruction, nothing is executed and the PC is left pointing
+at the opcode as well.
+
+A pending NMI is handled first, instead of executing an instruction. Next,
+an IRQ is handled if the `Irq` line is asserted and IrqDisable is not set.
+
+A Waiting Cpu executes nothing, but lets a single clock cycle pass. A
+Stopped Cpu has no running clock, so no cycles pass at all.
+
+The cycles used are passed on to Clocked memory, like timers, through
+the AddressBus.
+*/
+func (c *Cpu) Step() (uint8, error) {
+	if c.state == Stopped {
+		return 0, nil
+	}
+
+	PC := c.PC
+	cycles, err := c.step()
+	if cycles > 0 {
+		c.Bus.Tick(cycles)
+	}
+	if err != nil {
+		return cycles, err
+	}
+
+	if fault := c.Bus.takeFault(); fault != nil {
+		fault.PC, fault.HasPC = PC, true
+		return cycles, fault
+	}
+
+	return cycles, nil
+}
+
+// Handles a pending interrupt, or executes the next instruction.
+func (c *Cpu) step() (uint8, error) {
+	if c.nmiPending {
+		c.nmiPending = false
+		return c.serviceInterrupt(NmiVector), nil
+	}
+
+	if c.Irq != nil && c.Irq.Asserted() {
+		if !c.getIrqDisable() {
+			return c.serviceInterrupt(IrqVector), nil
+		}
+
+		// WAI resumes with the next instruction, even if IRQs are disabled
+		c.state = Running
+	}
+
+	if c.state == Waiting {
+		c.Cycles++
+		return 1, nil
+	}
+
+	instruction, err := c.readNextInstruction()
+	if fault := c.Bus.takeFault(); fault != nil {
+		// Don't execute what was read from unmapped memory
+		fault.PC, fault.HasPC = c.PC, true
+		return 0, fault
+	}
+	if err != nil {
+		return 0, err
+	}
+
 	c.PC += uint16(instruction.Size)
-	c.execute(instruction)
+
+	c.pageCrossed = false
+	c.extraCycles = 0
+	if err := c.execute(instruction); err != nil {
+		return 0, err
+	}
+
+	cycles := instruction.Cycles + c.extraCycles
+	c.Cycles += uint64(cycles)
+
+	return cycles, nil
 }
 
 // Handle the execution of an instruction
-func (c *Cpu) execute(instruction Instruction) {
+func (c *Cpu) execute(instruction Instruction) error {
 	switch instruction.opcodeId {
 	case nop:
-		break
+		// Undocumented NOPs with an operand do read from memory
+		if instruction.addressingId != implied && instruction.addressingId != immediate {
+			c.resolveOperand(instruction)
+		}
 	case adc:
 		c.adc(instruction)
 	case sbc:
@@ -240,9 +534,13 @@ func (c *Cpu) execute(instruction Instruction) {
 		}
 	case bit:
 		value := c.resolveOperand(instruction)
-		c.setNegative((value & 0x80) != 0)
-		c.setOverflow((value & 0x40) != 0)
 		c.setZero((c.A & value) == 0)
+
+		// BIT #immediate only affects the Zero flag
+		if instruction.addressingId != immediate {
+			c.setNegative((value & 0x80) != 0)
+			c.setOverflow((value & 0x40) != 0)
+		}
 	case php:
 		c.stackPush(c.P | 0x30)
 	case plp:
@@ -263,18 +561,96 @@ func (c *Cpu) execute(instruction Instruction) {
 	case rti:
 		c.setP(c.stackPop())
 		c.PC = uint16(c.stackPop()) | uint16(c.stackPop())<<8
+	case bra:
+		c.branch(instruction)
+	case phx:
+		c.stackPush(c.X)
+	case phy:
+		c.stackPush(c.Y)
+	case plx:
+		value := c.stackPop()
+		c.setX(value)
+	case ply:
+		value := c.stackPop()
+		c.setY(value)
+	case stz:
+		address := c.memoryAddress(instruction)
+		c.Bus.WriteByte(address, 0x00)
+	case trb:
+		c.trb(instruction)
+	case tsb:
+		c.tsb(instruction)
+	case rmb:
+		address := c.memoryAddress(instruction)
+		c.Bus.WriteByte(address, c.Bus.ReadByte(address)&^opcodeBit(instruction))
+	case smb:
+		address := c.memoryAddress(instruction)
+		c.Bus.WriteByte(address, c.Bus.ReadByte(address)|opcodeBit(instruction))
+	case bbr:
+		value := c.Bus.ReadByte(c.memoryAddress(instruction))
+		if (value & opcodeBit(instruction)) == 0 {
+			c.branch(instruction)
+		}
+	case bbs:
+		value := c.Bus.ReadByte(c.memoryAddress(instruction))
+		if (value & opcodeBit(instruction)) != 0 {
+			c.branch(instruction)
+		}
+	case wai:
+		c.state = Waiting
+	case stp:
+		c.state = Stopped
+	case slo:
+		c.slo(instruction)
+	case rla:
+		c.rla(instruction)
+	case sre:
+		c.sre(instruction)
+	case rra:
+		c.rra(instruction)
+	case sax:
+		address := c.memoryAddress(instruction)
+		c.Bus.WriteByte(address, c.A&c.X)
+	case lax:
+		value := c.resolveOperand(instruction)
+		c.setA(value)
+		c.setX(value)
+	case dcp:
+		c.dcp(instruction)
+	case isc:
+		c.isc(instruction)
+	case anc:
+		c.setA(c.A & c.resolveOperand(instruction))
+		c.setCarry(c.getNegative())
+	case alr:
+		c.setA(c.A & c.resolveOperand(instruction))
+		c.setCarry((c.A & 0x01) == 1)
+		c.setA(c.A >> 1)
+	case arr:
+		c.arr(instruction)
+	case sbx:
+		value := c.resolveOperand(instruction)
+		c.setCarry((c.A & c.X) >= value)
+		c.setX((c.A & c.X) - value)
+	case las:
+		value := c.resolveOperand(instruction) & c.SP
+		c.SP = value
+		c.setA(value)
+		c.setX(value)
 	default:
-		panic(fmt.Errorf("Unimplemented instruction: %s", instruction))
+		return &UnknownOpcodeError{PC: instruction.Address, Opcode: instruction.Opcode}
 	}
+
+	return nil
 }
 
-func (c *Cpu) readNextInstruction() Instruction {
+func (c *Cpu) readNextInstruction() (Instruction, error) {
 	// Read the opcode
 	opcode := c.Bus.ReadByte(c.PC)
 
-	optype, ok := opTypes[opcode]
+	optype, ok := c.opTypes[opcode]
 	if !ok {
-		panic(fmt.Sprintf("Unknown or unimplemented opcode 0x%02X\n%s", opcode, c.String()))
+		return Instruction{}, &UnknownOpcodeError{PC: c.PC, Opcode: opcode}
 	}
 
 	instruction := Instruction{OpType: optype, Address: c.PC}
@@ -286,16 +662,32 @@ func (c *Cpu) readNextInstruction() Instruction {
 		instruction.Op16 = c.Bus.Read16(c.PC + 1)
 	}
 
-	return instruction
+	return instruction, nil
 }
 
+// Take a branch. This costs an extra cycle, and one more if the
+// branch target is on another page.
 func (c *Cpu) branch(in Instruction) {
-	relative := int8(in.Op8) // Signed!
+	target := c.PC
+
+	offset := in.Op8
+	if in.addressingId == zeropageRelative {
+		offset = byte(in.Op16 >> 8)
+	}
+
+	relative := int8(offset) // Signed!
 	if relative >= 0 {
-		c.PC += uint16(relative)
+		target += uint16(relative)
 	} else {
-		c.PC -= -uint16(relative)
+		target -= -uint16(relative)
+	}
+
+	c.extraCycles++
+	if (c.PC & 0xFF00) != (target & 0xFF00) {
+		c.extraCycles++
 	}
+
+	c.PC = target
 }
 
 func (c *Cpu) resolveOperand(in Instruction) uint8 {
@@ -303,24 +695,48 @@ func (c *Cpu) resolveOperand(in Instruction) uint8 {
 	case immediate:
 		return in.Op8
 	default:
-		return c.Bus.ReadByte(c.memoryAddress(in))
+		address := c.memoryAddress(in)
+
+		// Indexed reads need an extra cycle to fix up the high byte
+		// of the address when crossing a page boundary.
+		if c.pageCrossed {
+			c.extraCycles++
+		}
+
+		return c.Bus.ReadByte(address)
 	}
 }
 
+// Add an index to a base address, noting if a page boundary was crossed.
+func (c *Cpu) indexAddress(base uint16, index byte) uint16 {
+	address := base + uint16(index)
+	c.pageCrossed = (base & 0xFF00) != (address & 0xFF00)
+
+	return address
+}
+
 func (c *Cpu) memoryAddress(in Instruction) uint16 {
 	switch in.addressingId {
 	case absolute:
 		return in.Op16
 	case absoluteX:
-		return in.Op16 + uint16(c.X)
+		return c.indexAddress(in.Op16, c.X)
 	case absoluteY:
-		return in.Op16 + uint16(c.Y)
+		return c.indexAddress(in.Op16, c.Y)
 	case indirect:
+		// The NMOS 6502 does not carry into the high byte of the pointer,
+		// so JMP ($xxFF) reads the high byte of the target from $xx00.
+		if c.variant != Wdc65C02 {
+			lo := uint16(c.Bus.ReadByte(in.Op16))
+			hi := uint16(c.Bus.ReadByte((in.Op16 & 0xFF00) | ((in.Op16 + 1) & 0x00FF)))
+			return (hi << 8) | lo
+		}
+
 		return c.Bus.Read16(in.Op16)
 	case indirectX:
-		return c.Bus.Read16(uint16(in.Op8 + c.X))
+		return c.readZeropage16(in.Op8 + c.X)
 	case indirectY:
-		return c.Bus.Read16(uint16(in.Op8)) + uint16(c.Y)
+		return c.indexAddress(c.readZeropage16(in.Op8), c.Y)
 	case relative:
 		panic("Relative addressing not yet implemented.")
 	case zeropage:
@@ -329,18 +745,38 @@ func (c *Cpu) memoryAddress(in Instruction) uint16 {
 		return uint16(in.Op8 + c.X)
 	case zeropageY:
 		return uint16(in.Op8 + c.Y)
+	case zeropageIndirect:
+		return c.readZeropage16(in.Op8)
+	case absoluteIndirectX:
+		return c.Bus.Read16(in.Op16 + uint16(c.X))
+	case zeropageRelative:
+		return uint16(byte(in.Op16))
 	default:
 		panic(fmt.Errorf("Unhandled addressing mode. Are you sure you are running a 6502 ROM?"))
 	}
 }
 
+// Read a 16-bit pointer from the zeropage. Like Read16, but the
+// high byte of a pointer at 0x00FF is read from 0x0000, not 0x0100.
+func (c *Cpu) readZeropage16(address byte) uint16 {
+	lo := uint16(c.Bus.ReadByte(uint16(address)))
+	hi := uint16(c.Bus.ReadByte(uint16(address + 1)))
+
+	return (hi << 8) | lo
+}
+
 // Add Memory to Accumulator with Carry
 func (c *Cpu) adc(in Instruction) {
-	operand := c.resolveOperand(in)
+	c.adcValue(c.resolveOperand(in))
+}
+
+// Add operand to Accumulator with Carry
+func (c *Cpu) adcValue(operand uint8) {
 	carryIn := c.getCarryInt()
 
 	if c.getDecimal() {
 		c.adcDecimal(c.A, operand, carryIn)
+		c.decimalFlags()
 	} else {
 		c.adcNormal(c.A, operand, carryIn)
 	}
@@ -348,32 +784,91 @@ func (c *Cpu) adc(in Instruction) {
 
 // Substract memory from Accummulator with carry
 func (c *Cpu) sbc(in Instruction) {
-	operand := c.resolveOperand(in)
+	c.sbcValue(c.resolveOperand(in))
+}
+
+// Substract operand from Accumulator with carry
+func (c *Cpu) sbcValue(operand uint8) {
 	carryIn := c.getCarryInt()
 
 	// fmt.Printf("SBC: A: 0x%02X V: 0x%02X C: %b D: %v\n", c.A, operand, carryIn, c.getDecimal())
 
 	if c.getDecimal() {
 		c.sbcDecimal(c.A, operand, carryIn)
+		c.decimalFlags()
 	} else {
 		c.adcNormal(c.A, ^operand, carryIn)
 	}
 }
 
+// The 65C02 sets the Negative and Zero flags according to the result of
+// decimal mode ADC and SBC. This costs an extra cycle.
+func (c *Cpu) decimalFlags() {
+	if c.variant == Wdc65C02 {
+		c.setArithmeticFlags(c.A)
+		c.extraCycles++
+	}
+}
+
 func (c *Cpu) inc(in Instruction) {
-	address := c.memoryAddress(in)
-	value := c.Bus.ReadByte(address) + 1
+	switch in.addressingId {
+	case accumulator:
+		c.setA(c.A + 1)
+	default:
+		address := c.memoryAddress(in)
+		value := c.Bus.ReadByte(address) + 1
 
-	c.Bus.WriteByte(address, value)
-	c.setArithmeticFlags(value)
+		c.Bus.WriteByte(address, value)
+		c.setArithmeticFlags(value)
+	}
 }
 
 func (c *Cpu) dec(in Instruction) {
+	switch in.addressingId {
+	case accumulator:
+		c.setA(c.A - 1)
+	default:
+		address := c.memoryAddress(in)
+		value := c.Bus.ReadByte(address) - 1
+
+		c.Bus.WriteByte(address, value)
+		c.setArithmeticFlags(value)
+	}
+}
+
+// Returns the bit operated on by RMB, SMB, BBR and BBS, which
+// is encoded in the high nibble of the opcode.
+func opcodeBit(in Instruction) byte {
+	return 1 << ((in.Opcode >> 4) & 0x07)
+}
+
+// Test and Reset Bits, clears the bits set in the Accumulator
+func (c *Cpu) trb(in Instruction) {
 	address := c.memoryAddress(in)
-	value := c.Bus.ReadByte(address) - 1
+	value := c.Bus.ReadByte(address)
 
-	c.Bus.WriteByte(address, value)
-	c.setArithmeticFlags(value)
+	c.setZero((c.A & value) == 0)
+	c.Bus.WriteByte(address, value&^c.A)
+}
+
+// Test and Set Bits, sets the bits set in the Accumulator
+func (c *Cpu) tsb(in Instruction) {
+	address := c.memoryAddress(in)
+	value := c.Bus.ReadByte(address)
+
+	c.setZero((c.A & value) == 0)
+	c.Bus.WriteByte(address, value|c.A)
+}
+
+// Returns the address for ASL, LSR, ROL and ROR. The 65C02 takes an extra
+// cycle when indexing crosses a page boundary.
+func (c *Cpu) shiftAddress(in Instruction) uint16 {
+	address := c.memoryAddress(in)
+	if c.variant == Wdc65C02 && c.pageCrossed {
+		c.extraCycles++
+	}
+
+	return address
 }
 
 func (c *Cpu) asl(in Instruction) {
@@ -383,7 +878,7 @@ func (c *Cpu) asl(in Instruction) {
 		c.A <<= 1
 		c.setArithmeticFlags(c.A)
 	default:
-		address := c.memoryAddress(in)
+		address := c.shiftAddress(in)
 		value := c.Bus.ReadByte(address)
 		c.setCarry((value >> 7) == 1)
 		value <<= 1
@@ -399,7 +894,7 @@ func (c *Cpu) lsr(in Instruction) {
 		c.A >>= 1
 		c.setArithmeticFlags(c.A)
 	default:
-		address := c.memoryAddress(in)
+		address := c.shiftAddress(in)
 		value := c.Bus.ReadByte(address)
 		c.setCarry((value & 0x01) == 1)
 		value >>= 1
@@ -417,7 +912,7 @@ func (c *Cpu) rol(in Instruction) {
 		c.A = c.A<<1 | carry
 		c.setArithmeticFlags(c.A)
 	default:
-		address := c.memoryAddress(in)
+		address := c.shiftAddress(in)
 		value := c.Bus.ReadByte(address)
 		c.setCarry((value & 0x80) != 0)
 		value = value<<1 | carry
@@ -435,7 +930,7 @@ func (c *Cpu) ror(in Instruction) {
 		c.A = c.A>>1 | carry<<7
 		c.setArithmeticFlags(c.A)
 	default:
-		address := c.memoryAddress(in)
+		address := c.shiftAddress(in)
 		value := c.Bus.ReadByte(address)
 		c.setCarry(value&0x01 == 1)
 		value = value>>1 | carry<<7
@@ -459,63 +954,59 @@ func (c *Cpu) adcNormal(a uint8, b uint8, carryIn uint8) {
 	c.setA(result)
 }
 
-// Performs addition in decimal mode
+// Performs addition in decimal mode. The Accumulator and Carry are correct
+// for invalid BCD values as well. Like the NMOS 6502, the Negative and
+// Overflow flags are set before the high digit is adjusted, and the Zero
+// flag from the binary sum.
 func (c *Cpu) adcDecimal(a uint8, b uint8, carryIn uint8) {
-	var carryB uint8 = 0
-
-	low := (a & 0x0F) + (b & 0x0F) + carryIn
-	if (low & 0xFF) > 9 {
-		low += 6
-	}
-	if low > 15 {
-		carryB = 1
+	low := int(a&0x0F) + int(b&0x0F) + int(carryIn)
+	if low > 0x09 {
+		low = ((low + 0x06) & 0x0F) + 0x10
 	}
 
-	high := (a >> 4) + (b >> 4) + carryB
-	if (high & 0xFF) > 9 {
-		high += 6
-	}
+	result := int(a&0xF0) + int(b&0xF0) + low
 
-	result := (low & 0x0F) | (high<<4)&0xF0
+	c.setNegative(result&0x80 != 0)
+	c.setOverflow((int(a)^result)&(int(b)^result)&0x80 != 0)
+	c.setZero(a+b+carryIn == 0)
 
-	c.setCarry(high > 15)
-	c.setZero(result == 0)
-	c.setNegative(false) // BCD never sets negative
-	c.setOverflow(false) // BCD never sets overflow
+	if result >= 0xA0 {
+		result += 0x60
+	}
 
-	c.A = result
+	c.setCarry(result >= 0x100)
+	c.A = uint8(result)
 }
 
+// Performs substraction in decimal mode. The flags are set as in binary
+// mode, only the Accumulator is adjusted. The NMOS 6502 and 65C02 adjust
+// invalid BCD values differently.
 func (c *Cpu) sbcDecimal(a uint8, b uint8, carryIn uint8) {
-	var carryB uint8 = 0
+	c.adcNormal(a, ^b, carryIn)
 
-	if carryIn == 0 {
-		carryIn = 1
-	} else {
-		carryIn = 0
-	}
+	borrow := 1 - int(carryIn)
+	low := int(a&0x0F) - int(b&0x0F) - borrow
 
-	low := (a & 0x0F) - (b & 0x0F) - carryIn
-	if (low & 0x10) != 0 {
-		low -= 6
-	}
-	if (low & 0x10) != 0 {
-		carryB = 1
-	}
-
-	high := (a >> 4) - (b >> 4) - carryB
-	if (high & 0x10) != 0 {
-		high -= 6
+	var result int
+	if c.variant == Wdc65C02 {
+		result = int(a) - int(b) - borrow
+		if result < 0 {
+			result -= 0x60
+		}
+		if low < 0 {
+			result -= 0x06
+		}
+	} else {
+		if low < 0 {
+			low = ((low - 0x06) & 0x0F) - 0x10
+		}
+		result = int(a&0xF0) - int(b&0xF0) + low
+		if result < 0 {
+			result -= 0x60
+		}
 	}
 
-	result := (low & 0x0F) | (high << 4)
-
-	c.setCarry((high & 0xFF) < 15)
-	c.setZero(result == 0)
-	c.setNegative(false) // BCD never sets negative
-	c.setOverflow(false) // BCD never sets overflow
-
-	c.A = result
+	c.A = uint8(result)
 }
 
 func (c *Cpu) stackPush(data byte) {
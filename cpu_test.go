@@ -1,8 +1,10 @@
 package i6502
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -10,16 +12,37 @@ import (
 
 // Creates a new machine, returning the different parts
 func NewRamMachine() (*Cpu, *AddressBus, *Ram) {
+	return NewRamMachineVariant(Mos6502)
+}
+
+// Creates a new machine with the given Cpu variant
+func NewRamMachineVariant(variant Variant) (*Cpu, *AddressBus, *Ram) {
 	ram, _ := NewRam(0x100000) // Full 64kB
 	bus, _ := NewAddressBus()
 	bus.Attach(ram, 0x0000)
-	cpu, _ := NewCpu(bus)
+	cpu, _ := NewCpu(bus, variant)
 
 	cpu.Reset()
 
 	return cpu, bus, ram
 }
 
+// Executes a single step, failing the test on errors. Returns the cycles used.
+func step(t *testing.T, cpu *Cpu) uint8 {
+	cycles, err := cpu.Step()
+	assert.Nil(t, err)
+
+	return cycles
+}
+
+// Runs for a number of cycles, failing the test on errors.
+func runCycles(t *testing.T, cpu *Cpu, cycles uint64) uint64 {
+	executed, err := cpu.RunCycles(cycles)
+	assert.Nil(t, err)
+
+	return executed
+}
+
 func loadProgram(path string) []byte {
 	data, err := ioutil.ReadFile(path)
 	if err != nil {
@@ -36,6 +59,24 @@ func TestNewCpu(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestNewCpuVariant(t *testing.T) {
+	cpu, err := NewCpu(nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, Mos6502, cpu.Variant())
+
+	cpu, err = NewCpu(nil, Wdc65C02)
+	assert.Nil(t, err)
+	assert.EqualValues(t, Wdc65C02, cpu.Variant())
+
+	cpu, err = NewCpu(nil, Variant(0xFF))
+	assert.NotNil(t, err)
+	assert.Nil(t, cpu)
+
+	cpu, err = NewCpu(nil, Mos6502, Wdc65C02)
+	assert.NotNil(t, err)
+	assert.Nil(t, cpu)
+}
+
 func TestStackPushPopPeek(t *testing.T) {
 	assert := assert.New(t)
 	cpu, _, _ := NewRamMachine()
@@ -103,8 +144,215 @@ func TestCpuInterrupt(t *testing.T) {
 	assert.EqualValues(t, 0x1234, cpu.PC)
 	assert.EqualValues(t, 0x03, cpu.Bus.ReadByte(0x01FF))
 	assert.EqualValues(t, 0x80, cpu.Bus.ReadByte(0x01FE))
-	assert.EqualValues(t, status, cpu.Bus.ReadByte(0x01FD))
+	assert.EqualValues(t, status&^0x10, cpu.Bus.ReadByte(0x01FD)) // Break flag cleared
+	assert.True(t, cpu.getIrqDisable())
+}
+
+func TestCpuInterruptIrqDisabled(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+
+	cpu.Bus.Write16(0xFFFE, 0x1234)
+	cpu.setIrqDisable(true)
+	cpu.PC = 0x0380
+
+	cpu.Interrupt()
+
+	assert.EqualValues(t, 0x0380, cpu.PC)
+	assert.EqualValues(t, 0xFF, cpu.SP)
+}
+
+func TestCpuIrqLine(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+
+	cpu.Bus.Write16(0xFFFE, 0x1234)
+	cpu.LoadProgram([]byte{0x78, 0x58, 0xEA}, 0x0380) // SEI, CLI, NOP
+	cpu.LoadProgram([]byte{0xEA}, 0x1234)
+	cpu.PC = 0x0380
+	cpu.setIrqDisable(true)
+
+	acia1, _ := AciaSubject()
+	acia2, _ := AciaSubject()
+	cpu.Irq.Assert(acia1)
+	cpu.Irq.Assert(acia2)
+
+	// Interrupts are disabled, so SEI and CLI execute
+	cpu.Step()
+	assert.EqualValues(t, 0x0381, cpu.PC)
+
+	cpu.Step()
+	assert.EqualValues(t, 0x0382, cpu.PC)
+
+	// IRQ is handled instead of NOP
+	assert.EqualValues(t, 7, step(t, cpu))
+	assert.EqualValues(t, 0x1234, cpu.PC)
+	assert.EqualValues(t, 0x03, cpu.Bus.ReadByte(0x01FF))
+	assert.EqualValues(t, 0x82, cpu.Bus.ReadByte(0x01FE))
+
+	// The handler runs with interrupts disabled
+	cpu.Step()
+	assert.EqualValues(t, 0x1235, cpu.PC)
+
+	// Still held by acia2 after acia1 releases
+	cpu.Irq.Release(acia1)
+	cpu.PC = 0x0382
+	cpu.setIrqDisable(false)
+	cpu.Step()
+	assert.EqualValues(t, 0x1234, cpu.PC)
+
+	cpu.Irq.Release(acia2)
+	cpu.PC = 0x0382
+	cpu.setIrqDisable(false)
+	cpu.Step()
+	assert.EqualValues(t, 0x0383, cpu.PC)
+}
+
+func TestCpuIrqLineIsNotBRK(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+
+	cpu.Bus.Write16(0xFFFE, 0x1234)
+	cpu.LoadProgram([]byte{0x58, 0xEA, 0x00, 0xEA, 0xEA}, 0x0380) // CLI, NOP, BRK, NOP
+	cpu.LoadProgram([]byte{
+		0x68,       // PLA
+		0x48,       // PHA
+		0x29, 0x10, // AND #$10
+		0xD0, 0x03, // BNE brk
+		0xE6, 0x10, // INC $10
+		0x40,       // RTI
+		0xE6, 0x11, // brk: INC $11
+		0x40, // RTI
+	}, 0x1234)
+	cpu.PC = 0x0380
+
+	// Counts IRQs and BRKs like a BIOS handler
+	step(t, cpu)
+	acia, _ := AciaSubject()
+	cpu.Irq.Assert(acia)
+	assert.Nil(t, cpu.Steps(6))
+	cpu.Irq.Release(acia)
+	assert.Nil(t, cpu.Steps(1))
+
+	assert.EqualValues(t, 0x0381, cpu.PC)
+	assert.EqualValues(t, 1, cpu.Bus.ReadByte(0x10))
+	assert.EqualValues(t, 0, cpu.Bus.ReadByte(0x11))
+
+	assert.Nil(t, cpu.Steps(8))
+	assert.EqualValues(t, 0x0384, cpu.PC)
+	assert.EqualValues(t, 1, cpu.Bus.ReadByte(0x10))
+	assert.EqualValues(t, 1, cpu.Bus.ReadByte(0x11))
+}
+
+func TestCpuNMIPreemptsPendingIrq(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+
+	cpu.Bus.Write16(0xFFFA, 0x2000)       // NMI vector
+	cpu.Bus.Write16(0xFFFE, 0x3000)       // IRQ vector
+	cpu.LoadProgram([]byte{0x40}, 0x2000) // NMI handler: RTI
+	cpu.LoadProgram([]byte{0xEA}, 0x3000) // IRQ handler: NOP
+	cpu.PC = 0x0380
+	cpu.setIrqDisable(false)
+
+	acia, _ := AciaSubject()
+	cpu.Irq.Assert(acia)
+	cpu.NMI()
+
+	// NMI first
+	cpu.Step()
+	assert.EqualValues(t, 0x2000, cpu.PC)
+
+	// NMI handler runs with interrupts disabled
+	cpu.Step()
+	assert.EqualValues(t, 0x0380, cpu.PC)
+
+	// RTI restored IrqDisable, the IRQ is still pending
+	cpu.Step()
+	assert.EqualValues(t, 0x3000, cpu.PC)
+}
+
+func TestCpuIrqLineWakesWaiting(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.Bus.Write16(0xFFFE, 0x1234)
+	cpu.LoadProgram([]byte{0xCB, 0xEA}, 0x0300) // WAI, NOP
+	cpu.setIrqDisable(true)
+
+	cpu.Step()
+	assert.EqualValues(t, Waiting, cpu.State())
+
+	// With IRQs disabled WAI continues with the next instruction
+	acia, _ := AciaSubject()
+	cpu.Irq.Assert(acia)
+	cpu.Step()
+
+	assert.EqualValues(t, Running, cpu.State())
+	assert.EqualValues(t, 0x0302, cpu.PC)
+}
+
+func TestCpuNMI(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+
+	cpu.Bus.Write16(0xFFFA, 0x1234) // Write the NMI vector
+	cpu.LoadProgram([]byte{0xEA}, 0x0380)
+	cpu.setIrqDisable(true) // NMI ignores IrqDisable
+
+	status := cpu.P
+
+	// Trigger NMI, twice before it's handled
+	cpu.NMI()
+	cpu.NMI()
+	assert.EqualValues(t, 0x0380, cpu.PC)
+
+	assert.EqualValues(t, 7, step(t, cpu))
+
+	assert.EqualValues(t, 0x1234, cpu.PC)
+	assert.EqualValues(t, 0x03, cpu.Bus.ReadByte(0x01FF))
+	assert.EqualValues(t, 0x80, cpu.Bus.ReadByte(0x01FE))
+	assert.EqualValues(t, status&^0x10, cpu.Bus.ReadByte(0x01FD))
+	assert.EqualValues(t, 0x00, cpu.Bus.ReadByte(0x01FD)&0x10) // Not a BRK
 	assert.True(t, cpu.getIrqDisable())
+
+	// Edge triggered, so handled only once
+	cpu.LoadProgram([]byte{0xEA}, 0x1234)
+	assert.EqualValues(t, 2, step(t, cpu))
+	assert.EqualValues(t, 0x1235, cpu.PC)
+}
+
+func TestCpuNMIPreemptsIrqHandler(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+
+	cpu.Bus.Write16(0xFFFA, 0x2000)       // NMI vector
+	cpu.Bus.Write16(0xFFFE, 0x3000)       // IRQ vector
+	cpu.LoadProgram([]byte{0x40}, 0x2000) // NMI handler: RTI
+	cpu.LoadProgram([]byte{0xEA}, 0x3000) // IRQ handler: NOP
+	cpu.PC = 0x0380
+	cpu.setIrqDisable(false)
+
+	// IRQ and NMI arrive together, NMI runs before the IRQ handler
+	cpu.Interrupt()
+	cpu.NMI()
+
+	cpu.Step()
+	assert.EqualValues(t, 0x2000, cpu.PC)
+
+	// Return from NMI into the IRQ handler
+	cpu.Step()
+	assert.EqualValues(t, 0x3000, cpu.PC)
+
+	cpu.Step()
+	assert.EqualValues(t, 0x3001, cpu.PC)
+}
+
+func TestCpuNMIWakesWaiting(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.Bus.Write16(0xFFFA, 0x1234)
+	cpu.LoadProgram([]byte{0xCB}, 0x0300)
+
+	cpu.Step()
+	assert.EqualValues(t, Waiting, cpu.State())
+
+	cpu.NMI()
+	cpu.Step()
+
+	assert.EqualValues(t, Running, cpu.State())
+	assert.EqualValues(t, 0x1234, cpu.PC)
 }
 
 func TestProgramLoading(t *testing.T) {
@@ -122,6 +370,170 @@ func TestProgramLoading(t *testing.T) {
 	assert.EqualValues(0x0300, cpu.PC)
 }
 
+func TestStepBusFault(t *testing.T) {
+	ram, _ := NewRam(0x8000)
+	rom, _ := NewRom("test/8kb.rom")
+	bus, _ := NewAddressBus()
+	bus.Attach(ram, 0x0000)
+	bus.Attach(rom, 0xE000)
+	cpu, _ := NewCpu(bus)
+
+	// STA $E000, STA $9000
+	cpu.LoadProgram([]byte{0x8D, 0x00, 0xE0, 0x8D, 0x00, 0x90}, 0x0300)
+
+	cycles, err := cpu.Step()
+	assert.EqualValues(t, 4, cycles)
+	assert.Equal(t, &BusFaultError{PC: 0x0300, HasPC: true, Address: 0xE000, Write: true, Reason: "Trying to write to read-only memory at 0xE000"}, err)
+	assert.EqualValues(t, "Bus fault writing 0xE000 at PC 0x0300: Trying to write to read-only memory at 0xE000", err.Error())
+
+	_, err = cpu.Step()
+	if fault, ok := err.(*BusFaultError); assert.True(t, ok) {
+		assert.EqualValues(t, 0x0303, fault.PC)
+		assert.EqualValues(t, 0x9000, fault.Address)
+	}
+
+	// Execution continues after a fault
+	assert.EqualValues(t, 0x0306, cpu.PC)
+}
+
+func TestStepFetchFault(t *testing.T) {
+	ram, _ := NewRam(0x8000)
+	bus, _ := NewAddressBus()
+	bus.Attach(ram, 0x0000)
+	cpu, _ := NewCpu(bus)
+	cpu.PC = 0x8000
+	SP, P := cpu.SP, cpu.P
+
+	cycles, err := cpu.Step()
+	assert.EqualValues(t, 0, cycles)
+	if fault, ok := err.(*BusFaultError); assert.True(t, ok) {
+		assert.EqualValues(t, 0x8000, fault.PC)
+		assert.EqualValues(t, 0x8000, fault.Address)
+	}
+
+	// Nothing read from unmapped memory is executed
+	assert.EqualValues(t, 0x8000, cpu.PC)
+	assert.EqualValues(t, SP, cpu.SP)
+	assert.EqualValues(t, P, cpu.P)
+	assert.EqualValues(t, 0, cpu.Cycles)
+}
+
+func TestStepsStopsOnError(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram([]byte{0xEA, 0x02, 0xEA}, 0x0300)
+
+	err := cpu.Steps(3)
+
+	assert.Equal(t, &UnknownOpcodeError{PC: 0x0301, Opcode: 0x02}, err)
+	assert.EqualValues(t, "Unknown or unimplemented opcode 0x02 at 0x0301", err.Error())
+	assert.EqualValues(t, 0x0301, cpu.PC)
+
+	executed, err := cpu.RunCycles(10)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, 0, executed)
+}
+
+func TestLoadProgramFault(t *testing.T) {
+	ram, _ := NewRam(0x0400)
+	bus, _ := NewAddressBus()
+	bus.Attach(ram, 0x0000)
+	cpu, _ := NewCpu(bus)
+
+	err := cpu.LoadProgram([]byte{0xEA, 0xEA, 0xEA}, 0x03FE)
+
+	if fault, ok := err.(*BusFaultError); assert.True(t, ok) {
+		assert.EqualValues(t, 0x0400, fault.Address)
+		assert.False(t, fault.HasPC)
+		assert.EqualValues(t, "Bus fault writing 0x0400: No addressable memory found at 0x0400", fault.Error())
+	}
+}
+
+//// Cycles
+
+func TestStepCycles(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram([]byte{0xEA, 0xAD, 0x00, 0x10, 0xEE, 0x00, 0x10}, 0x0300)
+
+	assert.EqualValues(t, 2, step(t, cpu)) // NOP
+	assert.EqualValues(t, 4, step(t, cpu)) // LDA $1000
+	assert.EqualValues(t, 6, step(t, cpu)) // INC $1000
+	assert.EqualValues(t, 12, cpu.Cycles)
+}
+
+func TestInterruptCycles(t *testing.T) {
+	cpu, bus, _ := NewRamMachine()
+	cpu.setIrqDisable(false)
+
+	via, _ := NewVia6522()
+	bus.AttachOverlay(via, 0x8000)
+	via.WriteByte(viaT2CL, 0x10)
+	via.WriteByte(viaT2CH, 0x00)
+
+	cpu.Interrupt()
+
+	// Clocked memory keeps up with the Cpu
+	assert.EqualValues(t, 7, cpu.Cycles)
+	assert.EqualValues(t, 0x09, via.Peek(viaT2CL))
+}
+
+func TestRunCycles(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+
+	// 10x NOP
+	cpu.LoadProgram([]byte{0xEA, 0xEA, 0xEA, 0xEA, 0xEA, 0xEA, 0xEA, 0xEA, 0xEA, 0xEA}, 0x0300)
+
+	assert.EqualValues(t, 6, runCycles(t, cpu, 6))
+	assert.EqualValues(t, 0x0303, cpu.PC)
+
+	// The last instruction overshoots the budget
+	assert.EqualValues(t, 4, runCycles(t, cpu, 3))
+	assert.EqualValues(t, 0x0305, cpu.PC)
+	assert.EqualValues(t, 10, cpu.Cycles)
+}
+
+// Expected cycle counts are taken from the MOS MCS6500 programming manual
+// (appendix A), which lists +1 for indexed reads crossing a page boundary,
+// +1 for taken branches and +2 for taken branches to another page.
+func TestCyclePenalties(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		x, y    byte
+		cycles  uint8
+	}{
+		{"LDA abs,X same page", []byte{0xBD, 0x00, 0x10}, 0xFF, 0x00, 4},
+		{"LDA abs,X page crossed", []byte{0xBD, 0xFF, 0x10}, 0x01, 0x00, 5},
+		{"LDA abs,Y same page", []byte{0xB9, 0x00, 0x10}, 0x00, 0xFF, 4},
+		{"LDA abs,Y page crossed", []byte{0xB9, 0xFF, 0x10}, 0x00, 0x01, 5},
+		{"LDA (ind),Y same page", []byte{0xB1, 0x80}, 0x00, 0x0F, 5},
+		{"LDA (ind),Y page crossed", []byte{0xB1, 0x80}, 0x00, 0x10, 6},
+		{"LDX abs,Y page crossed", []byte{0xBE, 0xFF, 0x10}, 0x00, 0x01, 5},
+		{"LDY abs,X page crossed", []byte{0xBC, 0xFF, 0x10}, 0x01, 0x00, 5},
+		{"ADC abs,X page crossed", []byte{0x7D, 0xFF, 0x10}, 0x01, 0x00, 5},
+		{"CMP (ind),Y page crossed", []byte{0xD1, 0x80}, 0x00, 0x10, 6},
+		{"EOR abs,Y page crossed", []byte{0x59, 0xFF, 0x10}, 0x00, 0x01, 5},
+		{"STA abs,X page crossed", []byte{0x9D, 0xFF, 0x10}, 0x01, 0x00, 5},
+		{"STA (ind),Y page crossed", []byte{0x91, 0x80}, 0x00, 0x10, 6},
+		{"INC abs,X page crossed", []byte{0xFE, 0xFF, 0x10}, 0x01, 0x00, 7},
+		{"ROL abs,X same page", []byte{0x3E, 0x00, 0x10}, 0x01, 0x00, 7},
+		{"BEQ not taken", []byte{0xF0, 0x10}, 0x01, 0x00, 2},
+		{"BNE taken", []byte{0xD0, 0x10}, 0x01, 0x00, 3},
+		{"BNE taken page crossed", []byte{0xD0, 0xF0}, 0x01, 0x00, 4},
+	}
+
+	for _, test := range tests {
+		cpu, _, _ := NewRamMachine()
+		cpu.Bus.Write16(0x0080, 0x10F0) // Pointer for (ind),Y
+		cpu.LoadProgram(test.program, 0x0300)
+		cpu.setZero(false)
+		cpu.X = test.x
+		cpu.Y = test.y
+
+		assert.EqualValues(t, test.cycles, step(t, cpu), test.name)
+		assert.EqualValues(t, test.cycles, cpu.Cycles, test.name)
+	}
+}
+
 //// NOP
 
 func TestNOP(t *testing.T) {
@@ -292,6 +704,32 @@ func TestADCDecimal(t *testing.T) {
 	assert.EqualValues(t, 0x47, cpu.A)
 }
 
+// The NMOS 6502 sets Negative and Overflow before adjusting the high
+// digit, and Zero from the binary sum.
+func TestADCDecimalFlags(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram([]byte{0x69, 0x01, 0x69, 0x00}, 0x0300)
+	cpu.setDecimal(true)
+	cpu.setCarry(false)
+	cpu.A = 0x99
+
+	cpu.Step()
+	assert.EqualValues(t, 0x00, cpu.A)
+	assert.True(t, cpu.getCarry())
+	assert.False(t, cpu.getZero())
+	assert.True(t, cpu.getNegative())
+	assert.False(t, cpu.getOverflow())
+
+	cpu.A = 0x79
+
+	cpu.Step()
+	assert.EqualValues(t, 0x80, cpu.A)
+	assert.False(t, cpu.getCarry())
+	assert.False(t, cpu.getZero())
+	assert.True(t, cpu.getNegative())
+	assert.True(t, cpu.getOverflow())
+}
+
 func TestADCZeropage(t *testing.T) {
 	cpu, _, _ := NewRamMachine()
 	cpu.LoadProgram([]byte{0x65, 0x53}, 0x0300)
@@ -383,6 +821,59 @@ func TestADCIndirectY(t *testing.T) {
 	assert.EqualValues(t, 0x54, cpu.A)
 }
 
+func TestIndirectXZeropageWrap(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram([]byte{0xA1, 0xFE}, 0x0300) // LDA ($FE,X)
+	cpu.X = 0x01
+	cpu.Bus.WriteByte(0x00FF, 0x34)
+	cpu.Bus.WriteByte(0x0000, 0x12)
+	cpu.Bus.WriteByte(0x0100, 0x56)
+	cpu.Bus.WriteByte(0x1234, 0x42)
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x42, cpu.A)
+}
+
+func TestIndirectXPointerWrap(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram([]byte{0xA1, 0xFF}, 0x0300) // LDA ($FF,X)
+	cpu.X = 0x02
+	cpu.Bus.Write16(0x0001, 0x1234) // $FF + 2 wraps to $01
+	cpu.Bus.WriteByte(0x1234, 0x42)
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x42, cpu.A)
+}
+
+func TestIndirectYZeropageWrap(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram([]byte{0xB1, 0xFF}, 0x0300) // LDA ($FF),Y
+	cpu.Y = 0x01
+	cpu.Bus.WriteByte(0x00FF, 0x34)
+	cpu.Bus.WriteByte(0x0000, 0x12)
+	cpu.Bus.WriteByte(0x0100, 0x56)
+	cpu.Bus.WriteByte(0x1235, 0x42)
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x42, cpu.A)
+}
+
+func TestZeropageIndirectWrap(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0xB2, 0xFF}, 0x0300) // LDA ($FF)
+	cpu.Bus.WriteByte(0x00FF, 0x34)
+	cpu.Bus.WriteByte(0x0000, 0x12)
+	cpu.Bus.WriteByte(0x0100, 0x56)
+	cpu.Bus.WriteByte(0x1234, 0x42)
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x42, cpu.A)
+}
+
 //// SBC
 
 func TestSBCImmediate(t *testing.T) {
@@ -437,6 +928,22 @@ func TestSBCDecimal(t *testing.T) {
 	assert.EqualValues(t, 0x28, cpu.A)
 }
 
+// The NMOS 6502 sets all flags of decimal SBC as in binary mode
+func TestSBCDecimalFlags(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram([]byte{0xE9, 0x01}, 0x0300)
+	cpu.setDecimal(true)
+	cpu.setCarry(true)
+	cpu.A = 0x00
+
+	cpu.Step()
+	assert.EqualValues(t, 0x99, cpu.A)
+	assert.False(t, cpu.getCarry())
+	assert.False(t, cpu.getZero())
+	assert.True(t, cpu.getNegative())
+	assert.False(t, cpu.getOverflow())
+}
+
 func TestSBCZero(t *testing.T) {
 	cpu, _, _ := NewRamMachine()
 	cpu.LoadProgram([]byte{0xE9, 0x42}, 0x0300)
@@ -3184,6 +3691,30 @@ func TestJMPIndirect(t *testing.T) {
 	assert.EqualValues(t, 0x1234, cpu.PC)
 }
 
+func TestJMPIndirectPageWrap(t *testing.T) {
+	for _, variant := range []Variant{Mos6502, Mos6502Undocumented} {
+		cpu, _, _ := NewRamMachineVariant(variant)
+		cpu.LoadProgram([]byte{0x6C, 0xFF, 0xC0}, 0x0300)
+		cpu.Bus.WriteByte(0xC0FF, 0x34)
+		cpu.Bus.WriteByte(0xC000, 0x12) // NMOS reads the high byte here
+		cpu.Bus.WriteByte(0xC100, 0x56)
+
+		assert.EqualValues(t, 5, step(t, cpu))
+		assert.EqualValues(t, 0x1234, cpu.PC)
+	}
+}
+
+func TestJMPIndirectPageWrap65C02(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x6C, 0xFF, 0xC0}, 0x0300)
+	cpu.Bus.WriteByte(0xC0FF, 0x34)
+	cpu.Bus.WriteByte(0xC000, 0x12)
+	cpu.Bus.WriteByte(0xC100, 0x56) // Fixed on the 65C02
+
+	assert.EqualValues(t, 6, step(t, cpu))
+	assert.EqualValues(t, 0x5634, cpu.PC)
+}
+
 //// JSR
 
 func TestJSR(t *testing.T) {
@@ -3233,23 +3764,731 @@ func TestRTI(t *testing.T) {
 	assert.EqualValues(t, 0x5B|0x20, cpu.P)
 }
 
-// Run this last, as the full suite takes ±10 seconds to run at
-// maximum speed
-func TestKlausDormann6502(t *testing.T) {
-	fmt.Println("Running Klaus Dormann' 6502 functional tests. This may take some time...")
+//// 65C02
+
+func TestMos6502Lacks65C02Instructions(t *testing.T) {
 	cpu, _, _ := NewRamMachine()
-	cpu.LoadProgram(loadProgram("test/6502_functional_test.bin"), 0x0000)
-	cpu.PC = 0x0400
-	prevPC := uint16(0x0400)
+	cpu.LoadProgram([]byte{0x80, 0x10}, 0x0300) // BRA +$10
 
-	for {
-		cpu.Step()
+	cycles, err := cpu.Step()
 
-		if cpu.PC == prevPC {
-			if cpu.PC != 0x3399 {
-				str := "Looping PC detected at PC 0x%04X. We've hit a failing Klaus Dormann test."
-				panic(fmt.Sprintf(str, cpu.PC))
-			} else {
+	assert.EqualValues(t, 0, cycles)
+	assert.Equal(t, &UnknownOpcodeError{PC: 0x0300, Opcode: 0x80}, err)
+	assert.EqualValues(t, 0x0300, cpu.PC)
+}
+
+func TestBRA(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x80, 0x10}, 0x0300)
+
+	assert.EqualValues(t, 3, step(t, cpu))
+	assert.EqualValues(t, 0x0312, cpu.PC)
+}
+
+func TestBRABackwards(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x80, 0xF0}, 0x0300)
+
+	assert.EqualValues(t, 4, step(t, cpu))
+	assert.EqualValues(t, 0x02F2, cpu.PC)
+}
+
+func TestPHXPLX(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0xDA, 0xA2, 0x00, 0xFA}, 0x0300)
+	cpu.X = 0x84
+
+	cpu.Step()
+	assert.EqualValues(t, 0x84, cpu.stackPeek())
+
+	cpu.Step()
+	assert.EqualValues(t, 0x00, cpu.X)
+
+	cpu.Step()
+	assert.EqualValues(t, 0x84, cpu.X)
+	assert.EqualValues(t, 0xFF, cpu.SP)
+	assert.True(t, cpu.getNegative())
+}
+
+func TestPHYPLY(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x5A, 0xA0, 0x01, 0x7A}, 0x0300)
+	cpu.Y = 0x00
+
+	cpu.Step()
+	assert.EqualValues(t, 0x00, cpu.stackPeek())
+
+	cpu.Step()
+	assert.EqualValues(t, 0x01, cpu.Y)
+
+	cpu.Step()
+	assert.EqualValues(t, 0x00, cpu.Y)
+	assert.EqualValues(t, 0xFF, cpu.SP)
+	assert.True(t, cpu.getZero())
+}
+
+func TestSTZ(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x64, 0x10, 0x74, 0x10, 0x9C, 0x00, 0x20, 0x9E, 0x00, 0x20}, 0x0300)
+	cpu.X = 0x01
+	cpu.Bus.WriteByte(0x0010, 0xFF)
+	cpu.Bus.WriteByte(0x0011, 0xFF)
+	cpu.Bus.WriteByte(0x2000, 0xFF)
+	cpu.Bus.WriteByte(0x2001, 0xFF)
+
+	cpu.Steps(4)
+
+	assert.EqualValues(t, 0x00, cpu.Bus.ReadByte(0x0010))
+	assert.EqualValues(t, 0x00, cpu.Bus.ReadByte(0x0011))
+	assert.EqualValues(t, 0x00, cpu.Bus.ReadByte(0x2000))
+	assert.EqualValues(t, 0x00, cpu.Bus.ReadByte(0x2001))
+}
+
+func TestTRB(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x14, 0x10, 0x1C, 0x00, 0x20}, 0x0300)
+	cpu.A = 0x0F
+	cpu.Bus.WriteByte(0x0010, 0xFF)
+	cpu.Bus.WriteByte(0x2000, 0xF0)
+
+	cpu.Step()
+	assert.EqualValues(t, 0xF0, cpu.Bus.ReadByte(0x0010))
+	assert.False(t, cpu.getZero())
+
+	cpu.Step()
+	assert.EqualValues(t, 0xF0, cpu.Bus.ReadByte(0x2000))
+	assert.True(t, cpu.getZero())
+}
+
+func TestTSB(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x04, 0x10, 0x0C, 0x00, 0x20}, 0x0300)
+	cpu.A = 0x0F
+	cpu.Bus.WriteByte(0x0010, 0xF0)
+	cpu.Bus.WriteByte(0x2000, 0x01)
+
+	cpu.Step()
+	assert.EqualValues(t, 0xFF, cpu.Bus.ReadByte(0x0010))
+	assert.True(t, cpu.getZero())
+
+	cpu.Step()
+	assert.EqualValues(t, 0x0F, cpu.Bus.ReadByte(0x2000))
+	assert.False(t, cpu.getZero())
+}
+
+func TestINCDECAccumulator(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x1A, 0x3A, 0x3A}, 0x0300)
+	cpu.A = 0xFF
+
+	cpu.Step()
+	assert.EqualValues(t, 0x00, cpu.A)
+	assert.True(t, cpu.getZero())
+
+	cpu.Step()
+	assert.EqualValues(t, 0xFF, cpu.A)
+	assert.True(t, cpu.getNegative())
+
+	cpu.Step()
+	assert.EqualValues(t, 0xFE, cpu.A)
+}
+
+func TestBITImmediate(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x89, 0xC0}, 0x0300)
+	cpu.A = 0x01
+	cpu.setNegative(false)
+	cpu.setOverflow(false)
+
+	cpu.Step()
+
+	// Only Zero is affected
+	assert.True(t, cpu.getZero())
+	assert.False(t, cpu.getNegative())
+	assert.False(t, cpu.getOverflow())
+}
+
+func TestBITIndexed(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x34, 0x10, 0x3C, 0x00, 0x20}, 0x0300)
+	cpu.A = 0x01
+	cpu.X = 0x02
+	cpu.Bus.WriteByte(0x0012, 0xC0)
+	cpu.Bus.WriteByte(0x2002, 0x01)
+
+	cpu.Step()
+	assert.True(t, cpu.getZero())
+	assert.True(t, cpu.getNegative())
+	assert.True(t, cpu.getOverflow())
+
+	cpu.Step()
+	assert.False(t, cpu.getZero())
+	assert.False(t, cpu.getNegative())
+	assert.False(t, cpu.getOverflow())
+}
+
+func TestZeropageIndirect(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0xB2, 0x20, 0x92, 0x22}, 0x0300)
+	cpu.Bus.Write16(0x0020, 0x2000)
+	cpu.Bus.Write16(0x0022, 0x3000)
+	cpu.Bus.WriteByte(0x2000, 0x42)
+
+	assert.EqualValues(t, 5, step(t, cpu))
+	assert.EqualValues(t, 0x42, cpu.A)
+
+	cpu.Step()
+	assert.EqualValues(t, 0x42, cpu.Bus.ReadByte(0x3000))
+}
+
+func TestJMPAbsoluteIndirectX(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x7C, 0x00, 0x20}, 0x0300)
+	cpu.X = 0x02
+	cpu.Bus.Write16(0x2002, 0x1234)
+
+	assert.EqualValues(t, 6, step(t, cpu))
+	assert.EqualValues(t, 0x1234, cpu.PC)
+}
+
+func TestADCDecimal65C02(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x69, 0x01}, 0x0300)
+	cpu.setDecimal(true)
+	cpu.setCarry(false)
+	cpu.A = 0x79
+
+	assert.EqualValues(t, 3, step(t, cpu))
+	assert.EqualValues(t, 0x80, cpu.A)
+	assert.True(t, cpu.getNegative())
+	assert.False(t, cpu.getZero())
+}
+
+func TestSBCDecimal65C02(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0xE9, 0x01}, 0x0300)
+	cpu.setDecimal(true)
+	cpu.setCarry(true)
+	cpu.A = 0x01
+
+	assert.EqualValues(t, 3, step(t, cpu))
+	assert.EqualValues(t, 0x00, cpu.A)
+	assert.True(t, cpu.getZero())
+	assert.True(t, cpu.getCarry())
+}
+
+func TestInterruptClearsDecimal65C02(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.setIrqDisable(false)
+	cpu.setDecimal(true)
+
+	cpu.Interrupt()
+
+	assert.False(t, cpu.getDecimal())
+}
+
+func TestRMB(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x07, 0x10, 0x77, 0x10}, 0x0300) // RMB0 $10, RMB7 $10
+	cpu.Bus.WriteByte(0x0010, 0xFF)
+
+	assert.EqualValues(t, 5, step(t, cpu))
+	assert.EqualValues(t, 0xFE, cpu.Bus.ReadByte(0x0010))
+
+	cpu.Step()
+	assert.EqualValues(t, 0x7E, cpu.Bus.ReadByte(0x0010))
+}
+
+func TestSMB(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x87, 0x10, 0xB7, 0x10}, 0x0300) // SMB0 $10, SMB3 $10
+	cpu.Bus.WriteByte(0x0010, 0x00)
+
+	assert.EqualValues(t, 5, step(t, cpu))
+	assert.EqualValues(t, 0x01, cpu.Bus.ReadByte(0x0010))
+
+	cpu.Step()
+	assert.EqualValues(t, 0x09, cpu.Bus.ReadByte(0x0010))
+}
+
+func TestBBR(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.Bus.WriteByte(0x0010, 0x02)
+
+	// BBR0 $10, +$10: bit 0 is reset, branch taken
+	cpu.LoadProgram([]byte{0x0F, 0x10, 0x10}, 0x0300)
+	assert.EqualValues(t, 6, step(t, cpu))
+	assert.EqualValues(t, 0x0313, cpu.PC)
+
+	// BBR1 $10, +$10: bit 1 is set, branch not taken
+	cpu.LoadProgram([]byte{0x1F, 0x10, 0x10}, 0x0300)
+	assert.EqualValues(t, 5, step(t, cpu))
+	assert.EqualValues(t, 0x0303, cpu.PC)
+}
+
+func TestBBS(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.Bus.WriteByte(0x0010, 0x80)
+
+	// BBS7 $10, -$10: bit 7 is set, branch taken to the previous page
+	cpu.LoadProgram([]byte{0xFF, 0x10, 0xF0}, 0x0300)
+	assert.EqualValues(t, 7, step(t, cpu))
+	assert.EqualValues(t, 0x02F3, cpu.PC)
+
+	// BBS6 $10, -$10: bit 6 is reset, branch not taken
+	cpu.LoadProgram([]byte{0xEF, 0x10, 0xF0}, 0x0300)
+	assert.EqualValues(t, 5, step(t, cpu))
+	assert.EqualValues(t, 0x0303, cpu.PC)
+}
+
+func TestUndefinedNOPs65C02(t *testing.T) {
+	for _, test := range []struct {
+		opcode byte
+		size   uint16
+		cycles uint8
+	}{
+		{0x02, 2, 2}, {0xE2, 2, 2}, {0x44, 2, 3}, {0x54, 2, 4}, {0xF4, 2, 4},
+		{0x5C, 3, 8}, {0xDC, 3, 4}, {0xFC, 3, 4},
+		{0x03, 1, 1}, {0xF3, 1, 1}, {0x0B, 1, 1}, {0xFB, 1, 1},
+	} {
+		cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+		cpu.LoadProgram([]byte{test.opcode, 0x10, 0x20}, 0x0300)
+		A, X, Y, P := cpu.A, cpu.X, cpu.Y, cpu.P
+
+		assert.EqualValues(t, test.cycles, step(t, cpu), "0x%02X", test.opcode)
+		assert.EqualValues(t, 0x0300+test.size, cpu.PC, "0x%02X", test.opcode)
+		assert.Equal(t, []byte{A, X, Y, P}, []byte{cpu.A, cpu.X, cpu.Y, cpu.P})
+	}
+}
+
+func TestShiftAbsoluteXCycles65C02(t *testing.T) {
+	for _, opcode := range []byte{0x1E, 0x5E, 0x3E, 0x7E} {
+		cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+		cpu.LoadProgram([]byte{opcode, 0x00, 0x10, opcode, 0xF0, 0x10}, 0x0300)
+		cpu.X = 0x20
+
+		assert.EqualValues(t, 6, step(t, cpu), "0x%02X", opcode)
+		assert.EqualValues(t, 7, step(t, cpu), "0x%02X page crossed", opcode)
+	}
+
+	// The NMOS 6502 always takes 7 cycles
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram([]byte{0x1E, 0x00, 0x10}, 0x0300)
+	assert.EqualValues(t, 7, step(t, cpu))
+}
+
+func TestZeropageRelativeString(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.LoadProgram([]byte{0x8F, 0x10, 0xF0}, 0x0300)
+
+	instruction, _ := cpu.readNextInstruction()
+	assert.Contains(t, instruction.String(), "BBS 0x10 0xF0 [zeropage,relative]")
+}
+
+func TestWAI(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.Bus.Write16(0xFFFE, 0x1234)
+	cpu.LoadProgram([]byte{0xCB, 0xEA}, 0x0300)
+	cpu.setIrqDisable(false)
+
+	assert.EqualValues(t, Running, cpu.State())
+	assert.EqualValues(t, 3, step(t, cpu))
+	assert.EqualValues(t, Waiting, cpu.State())
+
+	// Clock keeps running, nothing is executed
+	assert.EqualValues(t, 1, step(t, cpu))
+	assert.EqualValues(t, 10, runCycles(t, cpu, 10))
+	assert.EqualValues(t, 0x0301, cpu.PC)
+	assert.EqualValues(t, Waiting, cpu.State())
+
+	cpu.Interrupt()
+
+	assert.EqualValues(t, Running, cpu.State())
+	assert.EqualValues(t, 0x1234, cpu.PC)
+	assert.EqualValues(t, 0x01, cpu.Bus.ReadByte(0x01FE))
+}
+
+func TestSTP(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Wdc65C02)
+	cpu.Bus.Write16(0xFFFC, 0x0400)
+	cpu.LoadProgram([]byte{0xDB, 0xEA}, 0x0300)
+
+	assert.EqualValues(t, 3, step(t, cpu))
+	assert.EqualValues(t, Stopped, cpu.State())
+
+	// No clock, nothing is executed, interrupts are ignored
+	assert.EqualValues(t, 0, step(t, cpu))
+	assert.EqualValues(t, 0, runCycles(t, cpu, 100))
+	cpu.Interrupt()
+	assert.EqualValues(t, 0x0301, cpu.PC)
+	assert.EqualValues(t, Stopped, cpu.State())
+
+	cpu.Reset()
+
+	assert.EqualValues(t, Running, cpu.State())
+	assert.EqualValues(t, 0x0400, cpu.PC)
+}
+
+//// Undocumented NMOS 6502
+//
+// Expected results follow "No More Secrets - NMOS 6510 Unintended Opcodes".
+
+func TestMos6502LacksUndocumentedInstructions(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram([]byte{0xA7, 0x10}, 0x0300) // LAX $10
+
+	cycles, err := cpu.Step()
+
+	assert.EqualValues(t, 0, cycles)
+	assert.Equal(t, &UnknownOpcodeError{PC: 0x0300, Opcode: 0xA7}, err)
+	assert.EqualValues(t, 0x0300, cpu.PC)
+}
+
+func TestLAX(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0xA7, 0x10, 0xBF, 0xFF, 0x20}, 0x0300)
+	cpu.Bus.WriteByte(0x0010, 0x80)
+	cpu.Bus.WriteByte(0x2100, 0x00)
+	cpu.Y = 0x01
+
+	assert.EqualValues(t, 3, step(t, cpu))
+	assert.EqualValues(t, 0x80, cpu.A)
+	assert.EqualValues(t, 0x80, cpu.X)
+	assert.True(t, cpu.getNegative())
+
+	assert.EqualValues(t, 5, step(t, cpu)) // Page crossed
+	assert.EqualValues(t, 0x00, cpu.A)
+	assert.EqualValues(t, 0x00, cpu.X)
+	assert.True(t, cpu.getZero())
+}
+
+func TestSAX(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x87, 0x10}, 0x0300)
+	cpu.A = 0xF0
+	cpu.X = 0x3C
+	cpu.P = 0x00
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x30, cpu.Bus.ReadByte(0x0010))
+	assert.EqualValues(t, 0x00, cpu.P) // No flags affected
+}
+
+func TestSLO(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x07, 0x10}, 0x0300)
+	cpu.Bus.WriteByte(0x0010, 0x81)
+	cpu.A = 0x01
+
+	assert.EqualValues(t, 5, step(t, cpu))
+	assert.EqualValues(t, 0x02, cpu.Bus.ReadByte(0x0010))
+	assert.EqualValues(t, 0x03, cpu.A)
+	assert.True(t, cpu.getCarry())
+}
+
+func TestRLA(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x27, 0x10}, 0x0300)
+	cpu.Bus.WriteByte(0x0010, 0x81)
+	cpu.setCarry(true)
+	cpu.A = 0x0F
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x03, cpu.Bus.ReadByte(0x0010))
+	assert.EqualValues(t, 0x03, cpu.A)
+	assert.True(t, cpu.getCarry())
+}
+
+func TestSRE(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x47, 0x10}, 0x0300)
+	cpu.Bus.WriteByte(0x0010, 0x03)
+	cpu.A = 0xFF
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x01, cpu.Bus.ReadByte(0x0010))
+	assert.EqualValues(t, 0xFE, cpu.A)
+	assert.True(t, cpu.getCarry())
+	assert.True(t, cpu.getNegative())
+}
+
+func TestRRA(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x67, 0x10}, 0x0300)
+	cpu.Bus.WriteByte(0x0010, 0x02)
+	cpu.setCarry(true)
+	cpu.A = 0x10
+
+	cpu.Step()
+
+	// ROR shifts the carry in, and its own carry (0) goes into ADC
+	assert.EqualValues(t, 0x81, cpu.Bus.ReadByte(0x0010))
+	assert.EqualValues(t, 0x91, cpu.A)
+	assert.False(t, cpu.getCarry())
+}
+
+func TestDCP(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0xDB, 0xFF, 0x20}, 0x0300)
+	cpu.Bus.WriteByte(0x2100, 0x43)
+	cpu.Y = 0x01
+	cpu.A = 0x42
+
+	assert.EqualValues(t, 7, step(t, cpu)) // No page crossing penalty
+	assert.EqualValues(t, 0x42, cpu.Bus.ReadByte(0x2100))
+	assert.True(t, cpu.getZero())
+	assert.True(t, cpu.getCarry())
+}
+
+func TestISC(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0xE7, 0x10}, 0x0300)
+	cpu.Bus.WriteByte(0x0010, 0x00)
+	cpu.setCarry(true)
+	cpu.A = 0x05
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x01, cpu.Bus.ReadByte(0x0010))
+	assert.EqualValues(t, 0x04, cpu.A)
+	assert.True(t, cpu.getCarry())
+}
+
+func TestANC(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x0B, 0x80, 0x2B, 0x7F}, 0x0300)
+	cpu.A = 0xF0
+
+	cpu.Step()
+	assert.EqualValues(t, 0x80, cpu.A)
+	assert.True(t, cpu.getNegative())
+	assert.True(t, cpu.getCarry())
+
+	cpu.Step()
+	assert.EqualValues(t, 0x00, cpu.A)
+	assert.True(t, cpu.getZero())
+	assert.False(t, cpu.getCarry())
+}
+
+func TestALR(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x4B, 0x03}, 0x0300)
+	cpu.A = 0xFF
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x01, cpu.A)
+	assert.True(t, cpu.getCarry())
+}
+
+func TestARR(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x6B, 0xFF, 0x6B, 0xFF}, 0x0300)
+	cpu.setCarry(true)
+	cpu.A = 0xFF
+
+	// C is bit 6, V is bit 6 xor bit 5
+	cpu.Step()
+	assert.EqualValues(t, 0xFF, cpu.A)
+	assert.True(t, cpu.getCarry())
+	assert.False(t, cpu.getOverflow())
+
+	cpu.setCarry(false)
+	cpu.A = 0x80
+
+	cpu.Step()
+	assert.EqualValues(t, 0x40, cpu.A)
+	assert.True(t, cpu.getCarry())
+	assert.True(t, cpu.getOverflow())
+}
+
+func TestARRDecimal(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x6B, 0xFF}, 0x0300)
+	cpu.setDecimal(true)
+	cpu.setCarry(false)
+	cpu.A = 0x66
+
+	cpu.Step()
+
+	// 0x66 >> 1 = 0x33, both nibbles are fixed up
+	assert.EqualValues(t, 0x99, cpu.A)
+	assert.True(t, cpu.getCarry())
+}
+
+func TestSBX(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0xCB, 0x10}, 0x0300)
+	cpu.A = 0xF0
+	cpu.X = 0x3C
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x20, cpu.X)
+	assert.EqualValues(t, 0xF0, cpu.A)
+	assert.True(t, cpu.getCarry())
+}
+
+func TestLAS(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0xBB, 0x00, 0x20}, 0x0300)
+	cpu.Bus.WriteByte(0x2000, 0x5A)
+	cpu.SP = 0xF3
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x52, cpu.A)
+	assert.EqualValues(t, 0x52, cpu.X)
+	assert.EqualValues(t, 0x52, cpu.SP)
+}
+
+func TestUndocumentedSBC(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0xEB, 0x01}, 0x0300)
+	cpu.setCarry(true)
+	cpu.A = 0x05
+
+	cpu.Step()
+
+	assert.EqualValues(t, 0x04, cpu.A)
+}
+
+func TestUndocumentedNOPs(t *testing.T) {
+	cpu, _, _ := NewRamMachineVariant(Mos6502Undocumented)
+	cpu.LoadProgram([]byte{0x1A, 0x80, 0xFF, 0x04, 0x10, 0x14, 0x10, 0x0C, 0x00, 0x20, 0x1C, 0xFF, 0x20}, 0x0300)
+	cpu.X = 0x01
+	cpu.P = 0x00
+
+	assert.EqualValues(t, 2, step(t, cpu))
+	assert.EqualValues(t, 0x0301, cpu.PC)
+	assert.EqualValues(t, 2, step(t, cpu))
+	assert.EqualValues(t, 0x0303, cpu.PC)
+	assert.EqualValues(t, 3, step(t, cpu))
+	assert.EqualValues(t, 0x0305, cpu.PC)
+	assert.EqualValues(t, 4, step(t, cpu))
+	assert.EqualValues(t, 0x0307, cpu.PC)
+	assert.EqualValues(t, 4, step(t, cpu))
+	assert.EqualValues(t, 0x030A, cpu.PC)
+	assert.EqualValues(t, 5, step(t, cpu)) // Page crossed
+	assert.EqualValues(t, 0x030D, cpu.PC)
+
+	assert.EqualValues(t, 0x01, cpu.X)
+	assert.EqualValues(t, 0x00, cpu.P)
+}
+
+// Machine state in the SingleStepTests format
+type singleStepState struct {
+	PC  uint16      `json:"pc"`
+	S   byte        `json:"s"`
+	A   byte        `json:"a"`
+	X   byte        `json:"x"`
+	Y   byte        `json:"y"`
+	P   byte        `json:"p"`
+	Ram [][2]uint16 `json:"ram"`
+}
+
+type singleStepTest struct {
+	Name    string            `json:"name"`
+	Initial singleStepState   `json:"initial"`
+	Final   singleStepState   `json:"final"`
+	Cycles  []json.RawMessage `json:"cycles"`
+}
+
+// Runs Tom Harte's SingleStepTests for the undocumented instructions.
+// Run test/fetch-singlestep.sh to fetch the vectors from
+// https://github.com/SingleStepTests/65x02 into test/65x02/6502/v1.
+func TestSingleStepUndocumented(t *testing.T) {
+	directory := "test/65x02/6502/v1"
+	if _, err := os.Stat(directory); err != nil {
+		t.Skipf("SingleStepTests not found in %s, run test/fetch-singlestep.sh", directory)
+	}
+
+	for opcode := range mos6502UndocumentedOpTypes {
+		runSingleStepFile(t, fmt.Sprintf("%s/%02x.json", directory, opcode))
+	}
+}
+
+// Smoke test for the runner, with a few hand-written vectors in the
+// SingleStepTests format. These are not taken from the suite, and don't
+// verify the instructions.
+func TestSingleStepFormat(t *testing.T) {
+	runSingleStepFile(t, "test/singlestep_format.json")
+}
+
+// Runs the vectors in a SingleStepTests file, reporting only the first
+// failure.
+func runSingleStepFile(t *testing.T, path string) {
+	data, err := ioutil.ReadFile(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	var tests []singleStepTest
+	if !assert.Nil(t, json.Unmarshal(data, &tests)) {
+		return
+	}
+
+	for _, test := range tests {
+		if !runSingleStepTest(t, test) {
+			return
+		}
+	}
+}
+
+// Runs a single SingleStepTests vector, returns false if it fails.
+func runSingleStepTest(t *testing.T, test singleStepTest) bool {
+	cpu, bus, _ := NewRamMachineVariant(Mos6502Undocumented)
+
+	cpu.PC = test.Initial.PC
+	cpu.SP = test.Initial.S
+	cpu.A = test.Initial.A
+	cpu.X = test.Initial.X
+	cpu.Y = test.Initial.Y
+	cpu.P = test.Initial.P
+	for _, cell := range test.Initial.Ram {
+		bus.WriteByte(cell[0], byte(cell[1]))
+	}
+
+	cycles := step(t, cpu)
+
+	// Bits 4 and 5 don't exist in the status register
+	mask := byte(0xCF)
+
+	ok := assert.EqualValues(t, len(test.Cycles), cycles, test.Name) &&
+		assert.EqualValues(t, test.Final.PC, cpu.PC, test.Name) &&
+		assert.EqualValues(t, test.Final.S, cpu.SP, test.Name) &&
+		assert.EqualValues(t, test.Final.A, cpu.A, test.Name) &&
+		assert.EqualValues(t, test.Final.X, cpu.X, test.Name) &&
+		assert.EqualValues(t, test.Final.Y, cpu.Y, test.Name) &&
+		assert.EqualValues(t, test.Final.P&mask, cpu.P&mask, test.Name)
+
+	for _, cell := range test.Final.Ram {
+		ok = ok && assert.EqualValues(t, cell[1], bus.ReadByte(cell[0]), test.Name)
+	}
+
+	return ok
+}
+
+// Run this last, as the full suite takes ±10 seconds to run at
+// maximum speed
+func TestKlausDormann6502(t *testing.T) {
+	fmt.Println("Running Klaus Dormann' 6502 functional tests. This may take some time...")
+	cpu, _, _ := NewRamMachine()
+	cpu.LoadProgram(loadProgram("test/6502_functional_test.bin"), 0x0000)
+	cpu.PC = 0x0400
+	prevPC := uint16(0x0400)
+
+	for {
+		cpu.Step()
+
+		if cpu.PC == prevPC {
+			if cpu.PC != 0x3399 {
+				str := "Looping PC detected at PC 0x%04X. We've hit a failing Klaus Dormann test."
+				panic(fmt.Sprintf(str, cpu.PC))
+			} else {
 				fmt.Println("Klaus Dormann's 6502 functional tests passed.")
 				break
 			}
@@ -3258,3 +4497,31 @@ func TestKlausDormann6502(t *testing.T) {
 		prevPC = cpu.PC
 	}
 }
+
+// Each operation executes a single instruction, on a machine with a dozen
+// I/O devices attached between Ram and the Ram running the program.
+func BenchmarkStep(b *testing.B) {
+	ram, _ := NewRam(0x8000)
+	program, _ := NewRam(0x4000)
+	bus, _ := NewAddressBus()
+	bus.Attach(ram, 0x0000)
+	for i := 0; i < 12; i++ {
+		acia, _ := NewAcia6551(nil)
+		bus.Attach(acia, 0x8000+uint16(i)*0x10)
+	}
+	bus.Attach(program, 0xC000)
+	cpu, _ := NewCpu(bus)
+
+	cpu.LoadProgram([]byte{
+		0xA5, 0x10, // LDA $10
+		0x69, 0x01, // ADC #$01
+		0x85, 0x10, // STA $10
+		0xAD, 0xB1, 0x80, // LDA $80B1
+		0x4C, 0x00, 0xC0, // JMP $C000
+	}, 0xC000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cpu.Step()
+	}
+}
@@ -0,0 +1,107 @@
+package i6502
+
+// Undocumented instructions of the NMOS 6502, combining two regular
+// instructions. These are only available in the Mos6502Undocumented variant.
+
+// ASL memory, then ORA the result into the Accumulator
+func (c *Cpu) slo(in Instruction) {
+	address := c.memoryAddress(in)
+	value := c.Bus.ReadByte(address)
+
+	c.setCarry((value & 0x80) != 0)
+	value <<= 1
+
+	c.Bus.WriteByte(address, value)
+	c.setA(c.A | value)
+}
+
+// ROL memory, then AND the result into the Accumulator
+func (c *Cpu) rla(in Instruction) {
+	address := c.memoryAddress(in)
+	value := c.Bus.ReadByte(address)
+	carry := c.getCarryInt()
+
+	c.setCarry((value & 0x80) != 0)
+	value = value<<1 | carry
+
+	c.Bus.WriteByte(address, value)
+	c.setA(c.A & value)
+}
+
+// LSR memory, then EOR the result into the Accumulator
+func (c *Cpu) sre(in Instruction) {
+	address := c.memoryAddress(in)
+	value := c.Bus.ReadByte(address)
+
+	c.setCarry((value & 0x01) == 1)
+	value >>= 1
+
+	c.Bus.WriteByte(address, value)
+	c.setA(c.A ^ value)
+}
+
+// ROR memory, then ADC the result to the Accumulator
+func (c *Cpu) rra(in Instruction) {
+	address := c.memoryAddress(in)
+	value := c.Bus.ReadByte(address)
+	carry := c.getCarryInt()
+
+	c.setCarry((value & 0x01) == 1)
+	value = value>>1 | carry<<7
+
+	c.Bus.WriteByte(address, value)
+	c.adcValue(value)
+}
+
+// DEC memory, then CMP the result with the Accumulator
+func (c *Cpu) dcp(in Instruction) {
+	address := c.memoryAddress(in)
+	value := c.Bus.ReadByte(address) - 1
+
+	c.Bus.WriteByte(address, value)
+	c.setCarry(c.A >= value)
+	c.setArithmeticFlags(c.A - value)
+}
+
+// INC memory, then SBC the result from the Accumulator
+func (c *Cpu) isc(in Instruction) {
+	address := c.memoryAddress(in)
+	value := c.Bus.ReadByte(address) + 1
+
+	c.Bus.WriteByte(address, value)
+	c.sbcValue(value)
+}
+
+// AND the operand into the Accumulator, then ROR the Accumulator. The
+// Carry and Overflow flags are set in an unusual way, and decimal mode
+// applies a BCD fixup to the result.
+func (c *Cpu) arr(in Instruction) {
+	value := c.A & c.resolveOperand(in)
+	carry := c.getCarryInt()
+	result := value>>1 | carry<<7
+
+	if !c.getDecimal() {
+		c.setA(result)
+		c.setCarry((result & 0x40) != 0)
+		c.setOverflow(((result>>6)^(result>>5))&0x01 != 0)
+		return
+	}
+
+	// Flags are based on the result before the fixup
+	c.setNegative(carry == 1)
+	c.setZero(result == 0)
+	c.setOverflow(((value ^ result) & 0x40) != 0)
+
+	if (value&0x0F)+(value&0x01) > 0x05 {
+		result = (result & 0xF0) | ((result + 0x06) & 0x0F)
+	}
+
+	if uint16(value&0xF0)+uint16(value&0x10) > 0x50 {
+		c.setCarry(true)
+		result += 0x60
+	} else {
+		c.setCarry(false)
+	}
+
+	c.A = result
+}
@@ -47,8 +47,34 @@ Example: create an emulator using the full 64kB address space for RAM
     // Create the Cpu, with the AddressBus
     cpu, err := i6502.NewCpu(bus)
 
-The hardware pins `IRQ` and `RESB` are implemented and mapped to
-the functions `Interrupt()` and `Reset()`.
+By default the Cpu emulates an NMOS 6502. To emulate a WDC 65C02 instead,
+pass the Variant to NewCpu:
+
+    cpu, err := i6502.NewCpu(bus, i6502.Wdc65C02)
+
+Like the W65C02S, the 65C02 variant executes all undefined opcodes as
+NOPs of a fixed size and cycle count.
+
+Software relying on the stable undocumented NMOS instructions, like LAX
+and DCP, can use the Mos6502Undocumented variant.
+
+The hardware pins `IRQ`, `NMI` and `RESB` are implemented and mapped to
+the functions `Interrupt()`, `NMI()` and `Reset()`.
+
+Devices that hold IRQ low until they are serviced share the
+level-triggered `cpu.Irq` line. The Cpu handles the interrupt between
+instructions, as long as any device asserts the line:
+
+    cpu.Irq.Assert(device)
+    cpu.Irq.Release(device)
+
+Devices with timers, like the Via6522, are Clocked. The AddressBus
+passes the cycles of every Step on to them. Attach their IRQ output to
+the Cpu:
+
+    via, err := i6502.NewVia6522()
+    bus.Attach(via, 0x8000)
+    via.AttachIrq(cpu.Irq)
 
 Running a program from memory can be done by loading the binary
 data into memory using `LoadProgram`. Keep in mind that the first
@@ -65,9 +91,26 @@ Example of loading a binary program from disk into memory:
     // at 0x0200 and set cpu.PC to 0x0200 as well.
     cpu.LoadProgram(program, 0x0200)
 
+Intel HEX and Motorola S-record files contain their own load addresses,
+and optionally a start address for cpu.PC:
+
+    file, err := os.Open("program.hex")
+    err = cpu.LoadIntelHex(file)
+
+Use LoadIntelHex and LoadSRecord to load these files into any Memory,
+including Rom.
+
+Commodore PRG files, xa65 o65 relocatable objects and iNES cartridges
+are parsed with ParsePrg, ParseO65 and ParseINes, which expose the
+header details. Load them with cpu.LoadPrg, cpu.LoadO65 (relocating the
+object to a base address) or INes.Attach.
+
 With all memory connected and a program loaded, all that's left
 is executing instructions on the Cpu. A single call to `Step()` will
 read and execute a single (1, 2 or 3 byte) instruction from memory.
+It returns the number of clock cycles used, the running total is
+kept in `cpu.Cycles`. Use `RunCycles()` to execute instructions for
+a given number of clock cycles.
 
 To create a Cpu and have it running, simple create a go-routine.
 
@@ -75,5 +118,17 @@ To create a Cpu and have it running, simple create a go-routine.
         cpu.Step()
     }()
 
+Step returns an error when the Cpu runs into an unknown opcode
+(UnknownOpcodeError), or when the AddressBus reports a fault, like
+reading unmapped memory or writing to Rom (BusFaultError). It's up
+to you to log it, stop or Reset the Cpu.
+
+Access to unmapped memory can also be made to panic, or to behave
+like real hardware, where reads return a fixed value or whatever was
+last on the data bus:
+
+    bus.SetUnmappedPolicy(i6502.UnmappedOpenBus)
+    bus.IgnoreUnmappedWrites(true)
+
 */
 package i6502
@@ -0,0 +1,50 @@
+package i6502
+
+import "fmt"
+
+// Returned by Cpu.Step when the opcode at PC is not part of the
+// instruction set of the Cpu variant.
+type UnknownOpcodeError struct {
+	PC     uint16 // Address of the opcode
+	Opcode byte   // The unknown opcode
+}
+
+func (e *UnknownOpcodeError) Error() string {
+	return fmt.Sprintf("Unknown or unimplemented opcode 0x%02X at 0x%04X", e.Opcode, e.PC)
+}
+
+// Reported by the AddressBus when accessing an address that has no Memory
+// attached, or when writing to read-only Memory like Rom.
+type BusFaultError struct {
+	PC      uint16 // Address of the instruction causing the fault, if HasPC is set
+	HasPC   bool   // Whether the fault was caused by an instruction, set by the Cpu
+	Address uint16 // Address that was accessed
+	Write   bool   // Whether the fault was caused by a write
+	Reason  string // Description of the fault
+}
+
+func (e *BusFaultError) Error() string {
+	access := "reading"
+	if e.Write {
+		access = "writing"
+	}
+
+	message := fmt.Sprintf("Bus fault %s 0x%04X", access, e.Address)
+	if e.HasPC {
+		message += fmt.Sprintf(" at PC 0x%04X", e.PC)
+	}
+
+	return message + ": " + e.Reason
+}
+
+// Returned when loading a program file that is malformed, or does not fit
+// in the 16-bit address space.
+type LoadError struct {
+	Format string // File format, like "Intel HEX"
+	Line   int    // Line number in the file, starting at 1
+	Err    error  // What is wrong
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("%s line %d: %s", e.Format, e.Line, e.Err)
+}
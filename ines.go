@@ -0,0 +1,93 @@
+package i6502
+
+import "fmt"
+
+const (
+	inesHeaderSize  = 16
+	inesTrainerSize = 512
+	inesPrgBankSize = 0x4000
+	inesChrBankSize = 0x2000
+)
+
+/*
+An iNES cartridge image, the common format for NES programs. The 16-byte
+header describes the size of the PRG (program) and CHR (graphics) Rom and
+the mapper hardware on the cartridge.
+*/
+type INes struct {
+	Mapper     byte // Mapper number, 0 is NROM
+	Vertical   bool // Vertical nametable mirroring, horizontal otherwise
+	Battery    bool // Battery backed PRG Ram at 0x6000-7FFF
+	FourScreen bool // Four-screen nametable layout
+	PrgRamSize int  // Size of PRG Ram in bytes, 0 if unspecified
+
+	Trainer []byte // 512-byte trainer for 0x7000-71FF, if present
+	Prg     []byte // PRG Rom, in banks of 16kB
+	Chr     []byte // CHR Rom, in banks of 8kB
+}
+
+// Parse the content of an iNES file. The segments are copied from data.
+func ParseINes(data []byte) (*INes, error) {
+	if len(data) < inesHeaderSize || string(data[0:4]) != "NES\x1A" {
+		return nil, fmt.Errorf("Not an iNES file")
+	}
+
+	ines := &INes{
+		Mapper:     data[7]&0xF0 | data[6]>>4,
+		Vertical:   data[6]&0x01 != 0,
+		Battery:    data[6]&0x02 != 0,
+		FourScreen: data[6]&0x08 != 0,
+		PrgRamSize: int(data[8]) * 0x2000,
+	}
+
+	offset := inesHeaderSize
+	sizes := []int{0, int(data[4]) * inesPrgBankSize, int(data[5]) * inesChrBankSize}
+	if data[6]&0x04 != 0 {
+		sizes[0] = inesTrainerSize
+	}
+
+	for i, segment := range []*[]byte{&ines.Trainer, &ines.Prg, &ines.Chr} {
+		if offset+sizes[i] > len(data) {
+			return nil, fmt.Errorf("iNES file is truncated")
+		}
+
+		if sizes[i] > 0 {
+			*segment = append([]byte{}, data[offset:offset+sizes[i]]...)
+		}
+		offset += sizes[i]
+	}
+
+	return ines, nil
+}
+
+/*
+Attach the PRG Rom to the AddressBus at 0x8000-FFFF. Only mapper 0 (NROM)
+is supported: a single 16kB bank is mirrored, two banks fill the range.
+
+If there is a trainer, it is written to 0x7000-71FF. Attach PRG Ram at
+0x6000-7FFF first.
+*/
+func (n *INes) Attach(bus *AddressBus) error {
+	if n.Mapper != 0 {
+		return fmt.Errorf("iNES mapper %d is not supported", n.Mapper)
+	}
+
+	if len(n.Prg) != inesPrgBankSize && len(n.Prg) != 2*inesPrgBankSize {
+		return fmt.Errorf("NROM needs 1 or 2 PRG banks, found %d", len(n.Prg)/inesPrgBankSize)
+	}
+
+	rom, err := NewRomFromBytes(n.Prg)
+	if err != nil {
+		return err
+	}
+
+	if err := bus.AttachMirrored(rom, 0x8000, 0xFFFF); err != nil {
+		return err
+	}
+
+	for i, b := range n.Trainer {
+		bus.WriteByte(0x7000+uint16(i), b)
+	}
+
+	return bus.Fault()
+}
@@ -0,0 +1,89 @@
+package i6502
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Creates an iNES image with the given header flags and number of banks
+func INesSubject(prgBanks, chrBanks int, flags6, flags7 byte) []byte {
+	data := []byte{'N', 'E', 'S', 0x1A, byte(prgBanks), byte(chrBanks), flags6, flags7, 0x01, 0, 0, 0, 0, 0, 0, 0}
+
+	if flags6&0x04 != 0 {
+		data = append(data, make([]byte, inesTrainerSize)...)
+	}
+
+	prg := make([]byte, prgBanks*inesPrgBankSize)
+	for i := range prg {
+		prg[i] = byte(i >> 14)
+	}
+
+	return append(append(data, prg...), make([]byte, chrBanks*inesChrBankSize)...)
+}
+
+func TestParseINes(t *testing.T) {
+	ines, err := ParseINes(INesSubject(2, 1, 0x47, 0x10))
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0x14, ines.Mapper)
+	assert.True(t, ines.Vertical)
+	assert.True(t, ines.Battery)
+	assert.False(t, ines.FourScreen)
+	assert.Equal(t, 0x2000, ines.PrgRamSize)
+	assert.Len(t, ines.Trainer, inesTrainerSize)
+	assert.Len(t, ines.Prg, 0x8000)
+	assert.Len(t, ines.Chr, 0x2000)
+	assert.EqualValues(t, 0x01, ines.Prg[0x4000])
+}
+
+func TestParseINesErrors(t *testing.T) {
+	_, err := ParseINes([]byte("NES"))
+	assert.NotNil(t, err)
+
+	_, err = ParseINes(INesSubject(2, 1, 0x00, 0x00)[:0x6000])
+	assert.NotNil(t, err)
+}
+
+func TestINesAttach(t *testing.T) {
+	bus, _ := NewAddressBus()
+	ines, _ := ParseINes(INesSubject(1, 0, 0x00, 0x00))
+
+	// NROM-128 is mirrored
+	assert.Nil(t, ines.Attach(bus))
+	assert.EqualValues(t, 0x00, bus.ReadByte(0xC000))
+
+	bus, _ = NewAddressBus()
+	ines, _ = ParseINes(INesSubject(2, 0, 0x00, 0x00))
+
+	assert.Nil(t, ines.Attach(bus))
+	assert.EqualValues(t, 0x00, bus.ReadByte(0x8000))
+	assert.EqualValues(t, 0x01, bus.ReadByte(0xC000))
+	assert.Nil(t, bus.Fault())
+
+	ines, _ = ParseINes(INesSubject(2, 0, 0x10, 0x00))
+	assert.NotNil(t, ines.Attach(bus))
+}
+
+func TestINesAttachTrainer(t *testing.T) {
+	data := INesSubject(1, 0, 0x04, 0x00)
+	ines, _ := ParseINes(data)
+	ines.Trainer[0x01FF] = 0x42
+	ines.Prg[0] = 0x42
+
+	// Segments are copies
+	assert.EqualValues(t, 0x00, data[inesHeaderSize+0x01FF])
+	assert.EqualValues(t, 0x00, data[inesHeaderSize+inesTrainerSize])
+
+	// Needs PRG Ram for the trainer
+	bus, _ := NewAddressBus()
+	assert.NotNil(t, ines.Attach(bus))
+
+	bus, _ = NewAddressBus()
+	prgRam, _ := NewRam(0x2000)
+	bus.Attach(prgRam, 0x6000)
+
+	assert.Nil(t, ines.Attach(bus))
+	assert.EqualValues(t, 0x42, bus.ReadByte(0x71FF))
+	assert.EqualValues(t, 0x42, bus.ReadByte(0x8000))
+}
@@ -21,6 +21,10 @@ func (i Instruction) String() (output string) {
 	case 2:
 		output = fmt.Sprintf("~~~ 0x%04X: 0x%02X - %s 0x%02X [%s] {%d}\n", i.Address, i.Opcode, instructionNames[i.opcodeId], i.Op8, addressingNames[i.addressingId], i.Cycles)
 	case 3:
+		if i.addressingId == zeropageRelative {
+			output = fmt.Sprintf("~~~ 0x%04X: 0x%02X - %s 0x%02X 0x%02X [%s] {%d}\n", i.Address, i.Opcode, instructionNames[i.opcodeId], byte(i.Op16), byte(i.Op16>>8), addressingNames[i.addressingId], i.Cycles)
+			break
+		}
 		output = fmt.Sprintf("~~~ 0x%04X: 0x%02X - %s 0x%04X [%s] {%d}\n", i.Address, i.Opcode, instructionNames[i.opcodeId], i.Op16, addressingNames[i.addressingId], i.Cycles)
 	}
 
@@ -0,0 +1,132 @@
+package i6502
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	ihexData = iota
+	ihexEndOfFile
+	ihexExtendedSegmentAddress
+	ihexStartSegmentAddress
+	ihexExtendedLinearAddress
+	ihexStartLinearAddress
+)
+
+/*
+Load an Intel HEX file into memory, at the addresses in the file.
+
+Extended address records are supported, as long as all data ends up in
+the 16-bit address space. Start address records set Program.Start.
+
+A LoadError is returned for malformed records, checksum mismatches and
+data outside the 16-bit address space. Data before the error has already
+been written.
+*/
+func LoadIntelHex(r io.Reader, memory MemoryWriter) (*Program, error) {
+	program := &Program{}
+	base := uint32(0)
+	line := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		done, err := loadIntelHexRecord(text, memory, program, &base)
+		if err != nil {
+			return nil, &LoadError{Format: "Intel HEX", Line: line, Err: err}
+		}
+
+		if done {
+			return program, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, &LoadError{Format: "Intel HEX", Line: line, Err: fmt.Errorf("Missing end of file record")}
+}
+
+// Loads a single record, returns true for the end of file record.
+func loadIntelHexRecord(text string, memory MemoryWriter, program *Program, base *uint32) (bool, error) {
+	if text[0] != ':' {
+		return false, fmt.Errorf("Record does not start with ':'")
+	}
+
+	record, err := hex.DecodeString(text[1:])
+	if err != nil {
+		return false, fmt.Errorf("Invalid hex digits")
+	}
+
+	if len(record) < 5 || len(record) != int(record[0])+5 {
+		return false, fmt.Errorf("Invalid record length")
+	}
+
+	if sum := checksum(record[:len(record)-1]); byte(-sum) != record[len(record)-1] {
+		return false, fmt.Errorf("Checksum 0x%02X does not match 0x%02X", record[len(record)-1], byte(-sum))
+	}
+
+	address := uint32(record[1])<<8 | uint32(record[2])
+	data := record[4 : len(record)-1]
+
+	switch record[3] {
+	case ihexData:
+		if err := writeData(memory, *base+address, data); err != nil {
+			return false, err
+		}
+		program.Bytes += len(data)
+	case ihexEndOfFile:
+		return true, nil
+	case ihexExtendedSegmentAddress, ihexExtendedLinearAddress:
+		if len(data) != 2 {
+			return false, fmt.Errorf("Invalid extended address record")
+		}
+		*base = uint32(data[0])<<8 | uint32(data[1])
+		if record[3] == ihexExtendedSegmentAddress {
+			*base <<= 4
+		} else {
+			*base <<= 16
+		}
+		if *base > 0xFFFF {
+			return false, fmt.Errorf("Extended address 0x%X does not fit in the 16-bit address space", *base)
+		}
+	case ihexStartSegmentAddress, ihexStartLinearAddress:
+		if len(data) != 4 {
+			return false, fmt.Errorf("Invalid start address record")
+		}
+		start := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+		if record[3] == ihexStartSegmentAddress {
+			start = (start>>16)<<4 + start&0xFFFF
+		}
+		if start > 0xFFFF {
+			return false, fmt.Errorf("Start address 0x%X does not fit in the 16-bit address space", start)
+		}
+		program.Start = uint16(start)
+		program.HasStart = true
+	default:
+		return false, fmt.Errorf("Unknown record type 0x%02X", record[3])
+	}
+
+	return false, nil
+}
+
+// Returns the sum of all bytes, modulo 256.
+func checksum(data []byte) byte {
+	sum := byte(0)
+	for _, b := range data {
+		sum += b
+	}
+
+	return sum
+}
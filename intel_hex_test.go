@@ -0,0 +1,92 @@
+package i6502
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadIntelHex(t *testing.T) {
+	ram, _ := NewRam(0x10000)
+	file := strings.Join([]string{
+		":05020000A9428D00037E",
+		":020000040000FA",
+		":020000020010EC",
+		":020300000102F8", // 0x0010 * 16 + 0x0300
+		":0400000500000200F5",
+		":00000001FF",
+	}, "\n")
+
+	program, err := LoadIntelHex(strings.NewReader(file), ram)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Program{Start: 0x0200, HasStart: true, Bytes: 7}, program)
+	assert.EqualValues(t, 0xA9, ram.ReadByte(0x0200))
+	assert.EqualValues(t, 0x03, ram.ReadByte(0x0204))
+	assert.EqualValues(t, 0x01, ram.ReadByte(0x0400))
+	assert.EqualValues(t, 0x02, ram.ReadByte(0x0401))
+}
+
+func TestLoadIntelHexErrors(t *testing.T) {
+	ram, _ := NewRam(0x10000)
+
+	for file, message := range map[string]string{
+		":05020000A9428D00037F\n:00000001FF": "Intel HEX line 1: Checksum 0x7F does not match 0x7E",
+		"\n05020000A9428D00037E":             "Intel HEX line 2: Record does not start with ':'",
+		":05020000A9428D0003":                "Intel HEX line 1: Invalid record length",
+		":0502000ZA9428D00037E":              "Intel HEX line 1: Invalid hex digits",
+		":05020000A9428D00037E":              "Intel HEX line 1: Missing end of file record",
+		":02FFFF000102FD\n:00000001FF":       "Intel HEX line 1: Data at 0xFFFF does not fit in the 16-bit address space",
+		":020000040001F9\n:00000001FF":       "Intel HEX line 1: Extended address 0x10000 does not fit in the 16-bit address space",
+	} {
+		program, err := LoadIntelHex(strings.NewReader(file), ram)
+
+		assert.Nil(t, program)
+		if assert.NotNil(t, err, file) {
+			assert.Equal(t, message, err.Error())
+			assert.IsType(t, &LoadError{}, err)
+		}
+	}
+}
+
+func TestLoadIntelHexRom(t *testing.T) {
+	rom, _ := NewRomFromBytes(make([]byte, 0x2000))
+	file := ":05020000A9428D00037E\n:00000001FF\n"
+
+	_, err := LoadIntelHex(strings.NewReader(file), rom)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0xA9, rom.ReadByte(0x0200))
+	assert.EqualValues(t, 0x03, rom.ReadByte(0x0204))
+}
+
+func TestCpuLoadIntelHexRom(t *testing.T) {
+	rom, _ := NewRomFromBytes(make([]byte, 0x2000))
+	bus, _ := NewAddressBus()
+	bus.Attach(rom, 0xE000)
+	cpu, _ := NewCpu(bus)
+	file := ":05E00000A9428D0003A0\n:00000001FF\n"
+
+	assert.Nil(t, cpu.LoadIntelHex(strings.NewReader(file)))
+	assert.EqualValues(t, 0xA9, bus.ReadByte(0xE000))
+	assert.EqualValues(t, 0x03, bus.ReadByte(0xE004))
+
+	// Unmapped addresses are still reported
+	err := cpu.LoadIntelHex(strings.NewReader(":01020000EA13\n:00000001FF\n"))
+	if fault, ok := err.(*BusFaultError); assert.True(t, ok) {
+		assert.EqualValues(t, 0x0200, fault.Address)
+	}
+}
+
+func TestCpuLoadIntelHex(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	file := ":05020000A9428D00037E\n:0400000500000200F5\n:00000001FF\n"
+
+	assert.Nil(t, cpu.LoadIntelHex(strings.NewReader(file)))
+	assert.EqualValues(t, 0x0200, cpu.PC)
+
+	step(t, cpu)
+	step(t, cpu)
+	assert.EqualValues(t, 0x42, cpu.Bus.ReadByte(0x0300))
+}
@@ -0,0 +1,48 @@
+package i6502
+
+import "sync"
+
+/*
+The IrqLine emulates the active-low IRQ line of the 6502, shared by
+all devices that can request an interrupt.
+
+Devices pull the line low by asserting it, and it stays low until every
+source has released it again (wired-OR). The line is level-triggered: the
+Cpu checks it between instructions and handles the interrupt as long as the
+line is asserted and the IrqDisable flag is not set.
+
+It is safe to assert and release the line from different goroutines.
+*/
+type IrqLine struct {
+	mutex   sync.Mutex
+	sources map[interface{}]bool
+}
+
+// Create a new, released IrqLine
+func NewIrqLine() (*IrqLine, error) {
+	return &IrqLine{sources: make(map[interface{}]bool)}, nil
+}
+
+// Pull the line low on behalf of source, usually the device itself.
+func (l *IrqLine) Assert(source interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.sources[source] = true
+}
+
+// Stop pulling the line low on behalf of source.
+func (l *IrqLine) Release(source interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.sources, source)
+}
+
+// Returns true if any source is pulling the line low.
+func (l *IrqLine) Asserted() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return len(l.sources) > 0
+}
@@ -0,0 +1,36 @@
+package i6502
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewIrqLine(t *testing.T) {
+	line, err := NewIrqLine()
+
+	assert.Nil(t, err)
+	assert.False(t, line.Asserted())
+}
+
+func TestIrqLineWiredOr(t *testing.T) {
+	line, _ := NewIrqLine()
+	acia1, _ := AciaSubject()
+	acia2, _ := AciaSubject()
+
+	line.Assert(acia1)
+	line.Assert(acia2)
+	assert.True(t, line.Asserted())
+
+	// Asserting twice still needs a single release
+	line.Assert(acia1)
+	line.Release(acia1)
+	assert.True(t, line.Asserted())
+
+	line.Release(acia2)
+	assert.False(t, line.Asserted())
+
+	// Releasing an idle source is harmless
+	line.Release(acia2)
+	assert.False(t, line.Asserted())
+}
@@ -0,0 +1,45 @@
+package i6502
+
+import "fmt"
+
+/*
+Program files can be loaded into anything implementing MemoryWriter,
+like any Memory or the AddressBus. Memory implementing Poker, like Rom,
+is written with Poke, so program files can be loaded into Rom as well.
+*/
+type MemoryWriter interface {
+	WriteByte(address uint16, data byte)
+}
+
+// Details about a program loaded from a file.
+type Program struct {
+	Start    uint16 // Start address, if HasStart is set
+	HasStart bool   // Whether the file contains a start address
+	Bytes    int    // Number of bytes loaded
+}
+
+// Writes data at address, returning an error if it runs past the end of
+// the 16-bit address space.
+func writeData(memory MemoryWriter, address uint32, data []byte) error {
+	if address+uint32(len(data)) > 0x10000 {
+		return fmt.Errorf("Data at 0x%X does not fit in the 16-bit address space", address)
+	}
+
+	for i, b := range data {
+		load(memory, uint16(address)+uint16(i), b)
+	}
+
+	return nil
+}
+
+// Writes a single byte of a program file to memory.
+func load(memory MemoryWriter, address uint16, data byte) {
+	switch m := memory.(type) {
+	case *AddressBus:
+		m.load(address, data)
+	case Poker:
+		m.Poke(address, data)
+	default:
+		memory.WriteByte(address, data)
+	}
+}
@@ -9,3 +9,61 @@ type Memory interface {
 	ReadByte(address uint16) byte
 	WriteByte(address uint16, data byte)
 }
+
+/*
+Memory that cannot be written to, like Rom, implements ReadOnly. The
+AddressBus checks this when the Memory is attached, and reports a
+BusFaultError for writes instead of calling WriteByte.
+*/
+type ReadOnly interface {
+	ReadOnly() bool
+}
+
+/*
+Memory that keeps time with the Cpu, like timers, implements Clocked.
+The AddressBus passes the clock cycles used by every Cpu Step to all
+attached Clocked memory.
+*/
+type Clocked interface {
+	Tick(cycles uint8)
+}
+
+/*
+Memory with side effects on reads, like IO registers, implements Peeker
+to let debuggers and memory dumps inspect it without changing its state.
+*/
+type Peeker interface {
+	Peek(address uint16) byte
+}
+
+/*
+Memory implements Poker to let debuggers change its content directly,
+bypassing write protection and side effects, like patching a Rom.
+*/
+type Poker interface {
+	Poke(address uint16, data byte)
+}
+
+// Reads from memory with Peek, if implemented, or ReadByte otherwise.
+func peek(memory Memory, address uint16) byte {
+	if peeker, ok := memory.(Peeker); ok {
+		return peeker.Peek(address)
+	}
+
+	return memory.ReadByte(address)
+}
+
+// Writes to memory with Poke, if implemented, or WriteByte otherwise.
+// Read-only memory without Poke is left untouched.
+func poke(memory Memory, address uint16, data byte) {
+	if poker, ok := memory.(Poker); ok {
+		poker.Poke(address, data)
+		return
+	}
+
+	if readOnly, ok := memory.(ReadOnly); ok && readOnly.ReadOnly() {
+		return
+	}
+
+	memory.WriteByte(address, data)
+}
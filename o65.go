@@ -0,0 +1,263 @@
+package i6502
+
+import "fmt"
+
+const (
+	o65Mode65816   = 0x8000
+	o65ModePaged   = 0x4000
+	o65ModeLong    = 0x2000
+	o65ModeBssZero = 0x0200
+
+	o65RelocWord = 0x80
+	o65RelocHigh = 0x40
+	o65RelocLow  = 0x20
+
+	o65SegmentUndefined = 0
+	o65SegmentAbsolute  = 1
+	o65SegmentText      = 2
+	o65SegmentData      = 3
+	o65SegmentBss       = 4
+	o65SegmentZero      = 5
+)
+
+/*
+A relocatable object in the o65 format, as produced by the xa65 assembler.
+
+The text and data segments can be moved to any address with Relocate.
+Undefined references to other objects are not supported, as there is no
+linker.
+*/
+type O65 struct {
+	Mode uint16 // Mode word from the header
+
+	TextBase, TextLen uint16 // Code segment
+	DataBase, DataLen uint16 // Initialized data segment
+	BssBase, BssLen   uint16 // Uninitialized data segment
+	ZeroBase, ZeroLen uint16 // Zeropage segment
+	Stack             uint16 // Stack size needed
+
+	Options   []O65Option // Header options, like the file name
+	Text      []byte      // Content of the text segment
+	Data      []byte      // Content of the data segment
+	Undefined []string    // Undefined references
+	Globals   []O65Global // Exported symbols
+
+	textRelocs []*o65Relocation
+	dataRelocs []*o65Relocation
+}
+
+// An o65 header option
+type O65Option struct {
+	Type byte
+	Data []byte
+}
+
+// A symbol exported by an o65 object
+type O65Global struct {
+	Name    string
+	Segment byte   // Segment ID, 2 for text, 3 for data, etc.
+	Value   uint16 // Address, updated by Relocate
+}
+
+type o65Relocation struct {
+	offset    int  // Offset in the segment
+	kind      byte // o65RelocWord, o65RelocHigh or o65RelocLow
+	segment   byte // Segment the value refers to
+	low       byte // Low byte of the value, for o65RelocHigh
+	undefined uint16
+}
+
+// Reads values from o65 data, remembering the first error.
+type o65Reader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *o65Reader) bytes(n int) []byte {
+	if r.err != nil || r.pos+n > len(r.data) {
+		r.err = fmt.Errorf("o65 file is truncated")
+		return make([]byte, n)
+	}
+
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+
+	return b
+}
+
+func (r *o65Reader) byte() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *o65Reader) word() uint16 {
+	b := r.bytes(2)
+	return uint16(b[1])<<8 | uint16(b[0])
+}
+
+func (r *o65Reader) string() string {
+	start := r.pos
+	for r.err == nil && r.byte() != 0x00 {
+	}
+
+	if r.err != nil {
+		return ""
+	}
+
+	return string(r.data[start : r.pos-1])
+}
+
+// Parse the content of an o65 file. Only 16-bit 6502 objects are supported.
+func ParseO65(data []byte) (*O65, error) {
+	r := &o65Reader{data: data}
+
+	if string(r.bytes(5)) != "\x01\x00o65" || r.byte() != 0x00 {
+		return nil, fmt.Errorf("Not an o65 file")
+	}
+
+	o := &O65{Mode: r.word()}
+	if o.Mode&o65ModeLong != 0 {
+		return nil, fmt.Errorf("o65 files with 32-bit sizes are not supported")
+	}
+	if o.Mode&o65Mode65816 != 0 {
+		return nil, fmt.Errorf("o65 files for the 65816 are not supported")
+	}
+
+	for _, field := range []*uint16{&o.TextBase, &o.TextLen, &o.DataBase, &o.DataLen, &o.BssBase, &o.BssLen, &o.ZeroBase, &o.ZeroLen, &o.Stack} {
+		*field = r.word()
+	}
+
+	for length := r.byte(); length != 0 && r.err == nil; length = r.byte() {
+		if length < 2 {
+			return nil, fmt.Errorf("Invalid o65 header option length %d", length)
+		}
+
+		o.Options = append(o.Options, O65Option{Type: r.byte(), Data: r.bytes(int(length) - 2)})
+	}
+
+	// Copied, so relocating doesn't change data
+	o.Text = append([]byte{}, r.bytes(int(o.TextLen))...)
+	o.Data = append([]byte{}, r.bytes(int(o.DataLen))...)
+
+	for count := int(r.word()); count > 0 && r.err == nil; count-- {
+		o.Undefined = append(o.Undefined, r.string())
+	}
+
+	var err error
+	if o.textRelocs, err = o.readRelocations(r, len(o.Text)); err != nil {
+		return nil, err
+	}
+	if o.dataRelocs, err = o.readRelocations(r, len(o.Data)); err != nil {
+		return nil, err
+	}
+
+	for count := int(r.word()); count > 0 && r.err == nil; count-- {
+		o.Globals = append(o.Globals, O65Global{Name: r.string(), Segment: r.byte(), Value: r.word()})
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return o, nil
+}
+
+func (o *O65) readRelocations(r *o65Reader, size int) ([]*o65Relocation, error) {
+	relocs := make([]*o65Relocation, 0)
+	offset := -1
+
+	for b := r.byte(); b != 0 && r.err == nil; b = r.byte() {
+		if b == 0xFF {
+			offset += 0xFE
+			continue
+		}
+		offset += int(b)
+
+		kind := r.byte()
+		reloc := &o65Relocation{offset: offset, kind: kind & 0xE0, segment: kind & 0x0F}
+
+		if reloc.segment == o65SegmentUndefined {
+			reloc.undefined = r.word()
+			if int(reloc.undefined) >= len(o.Undefined) {
+				return nil, fmt.Errorf("Relocation refers to unknown undefined reference %d", reloc.undefined)
+			}
+		}
+
+		length := 1
+		switch reloc.kind {
+		case o65RelocWord:
+			length = 2
+		case o65RelocHigh:
+			if o.Mode&o65ModePaged == 0 {
+				reloc.low = r.byte()
+			}
+		case o65RelocLow:
+		default:
+			return nil, fmt.Errorf("Unsupported o65 relocation type 0x%02X", kind)
+		}
+
+		if offset+length > size {
+			return nil, fmt.Errorf("Relocation at offset %d is outside the segment", offset)
+		}
+
+		relocs = append(relocs, reloc)
+	}
+
+	return relocs, r.err
+}
+
+/*
+Move the segments to the given base addresses, adjusting all addresses
+in the text and data segments, and the exported Globals.
+
+To load the object in one contiguous block, place the data segment right
+after the text segment:
+
+	o.Relocate(base, base+o.TextLen, base+o.TextLen+o.DataLen, o.ZeroBase)
+
+An error is returned if the object has undefined references.
+*/
+func (o *O65) Relocate(text, data, bss, zero uint16) error {
+	if len(o.Undefined) > 0 {
+		return fmt.Errorf("Undefined reference to %s", o.Undefined[0])
+	}
+
+	diffs := map[byte]uint16{
+		o65SegmentAbsolute: 0,
+		o65SegmentText:     text - o.TextBase,
+		o65SegmentData:     data - o.DataBase,
+		o65SegmentBss:      bss - o.BssBase,
+		o65SegmentZero:     zero - o.ZeroBase,
+	}
+
+	for _, reloc := range o.textRelocs {
+		reloc.apply(o.Text, diffs[reloc.segment])
+	}
+
+	for _, reloc := range o.dataRelocs {
+		reloc.apply(o.Data, diffs[reloc.segment])
+	}
+
+	for i := range o.Globals {
+		o.Globals[i].Value += diffs[o.Globals[i].Segment]
+	}
+
+	o.TextBase, o.DataBase, o.BssBase, o.ZeroBase = text, data, bss, zero
+
+	return nil
+}
+
+func (r *o65Relocation) apply(segment []byte, diff uint16) {
+	switch r.kind {
+	case o65RelocWord:
+		value := uint16(segment[r.offset+1])<<8 | uint16(segment[r.offset]) + diff
+		segment[r.offset] = byte(value)
+		segment[r.offset+1] = byte(value >> 8)
+	case o65RelocHigh:
+		value := uint16(segment[r.offset])<<8 | uint16(r.low) + diff
+		segment[r.offset] = byte(value >> 8)
+		r.low = byte(value)
+	case o65RelocLow:
+		segment[r.offset] += byte(diff)
+	}
+}
@@ -0,0 +1,92 @@
+package i6502
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Assembled at 0x1000 with data at 0x2000:
+//
+//	start: LDA start+5
+//	       JMP start
+//	.data
+//	       .byt <start+3, >start+3
+func O65Subject() []byte {
+	return []byte{
+		0x01, 0x00, 'o', '6', '5', 0x00, // Marker and version
+		0x00, 0x00, // Mode
+		0x00, 0x10, 0x06, 0x00, // Text
+		0x00, 0x20, 0x02, 0x00, // Data
+		0x02, 0x20, 0x10, 0x00, // Bss
+		0x00, 0x00, 0x00, 0x00, // Zero
+		0x00, 0x00, // Stack
+		0x06, 0x00, 'a', '.', 'o', 0x00, // File name option
+		0x00,
+		0xAD, 0x05, 0x10, 0x4C, 0x00, 0x10, // Text
+		0x03, 0x10, // Data
+		0x00, 0x00, // Undefined references
+		0x02, 0x82, 0x03, 0x82, 0x00, // Text relocations
+		0x01, 0x22, 0x01, 0x42, 0x03, 0x00, // Data relocations
+		0x01, 0x00, 's', 't', 'a', 'r', 't', 0x00, 0x02, 0x00, 0x10, // Globals
+	}
+}
+
+func TestParseO65(t *testing.T) {
+	o, err := ParseO65(O65Subject())
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0x1000, o.TextBase)
+	assert.EqualValues(t, 0x2000, o.DataBase)
+	assert.EqualValues(t, 0x10, o.BssLen)
+	assert.Equal(t, []O65Option{{Type: 0x00, Data: []byte("a.o\x00")}}, o.Options)
+	assert.Equal(t, []byte{0xAD, 0x05, 0x10, 0x4C, 0x00, 0x10}, o.Text)
+	assert.Equal(t, []O65Global{{Name: "start", Segment: 2, Value: 0x1000}}, o.Globals)
+}
+
+func TestParseO65Errors(t *testing.T) {
+	data := O65Subject()
+
+	_, err := ParseO65(data[:40])
+	assert.NotNil(t, err)
+
+	_, err = ParseO65([]byte("not o65"))
+	assert.NotNil(t, err)
+
+	data[7] = 0x20 // 32-bit sizes
+	_, err = ParseO65(data)
+	assert.NotNil(t, err)
+}
+
+func TestO65Relocate(t *testing.T) {
+	data := O65Subject()
+	original := append([]byte{}, data...)
+	o, _ := ParseO65(data)
+
+	assert.Nil(t, o.Relocate(0x0400, 0x0406, 0x0408, 0x0000))
+	assert.Equal(t, []byte{0xAD, 0x05, 0x04, 0x4C, 0x00, 0x04}, o.Text)
+	assert.Equal(t, []byte{0x03, 0x04}, o.Data)
+	assert.EqualValues(t, 0x0400, o.Globals[0].Value)
+
+	// Relocating again starts from the current bases
+	assert.Nil(t, o.Relocate(0x12F0, 0x3000, 0x3002, 0x0000))
+	assert.Equal(t, []byte{0xAD, 0xF5, 0x12, 0x4C, 0xF0, 0x12}, o.Text)
+	assert.Equal(t, []byte{0xF3, 0x12}, o.Data)
+
+	// The parsed file is left alone
+	assert.Equal(t, original, data)
+}
+
+func TestCpuLoadO65(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	o, _ := ParseO65(O65Subject())
+
+	assert.Nil(t, cpu.LoadO65(o, 0x0300))
+	assert.EqualValues(t, 0x0300, cpu.PC)
+	assert.EqualValues(t, 0x03, cpu.Bus.ReadByte(0x0306))
+
+	step(t, cpu)
+	assert.EqualValues(t, 0x03, cpu.A) // High byte of the relocated JMP
+	step(t, cpu)
+	assert.EqualValues(t, 0x0300, cpu.PC)
+}
@@ -16,6 +16,9 @@ const (
 	zeropage
 	zeropageX
 	zeropageY
+	zeropageIndirect
+	absoluteIndirectX
+	zeropageRelative
 )
 
 var addressingNames = [...]string{
@@ -33,6 +36,9 @@ var addressingNames = [...]string{
 	"zeropage",
 	"zeropageX",
 	"zeropageY",
+	"(zeropage)",
+	"(absolute,X)",
+	"zeropage,relative",
 }
 
 // OpCode table
@@ -94,6 +100,37 @@ const (
 	txa
 	txs
 	tya
+
+	// 65C02
+	bra
+	phx
+	phy
+	plx
+	ply
+	stz
+	trb
+	tsb
+	rmb
+	smb
+	bbr
+	bbs
+	wai
+	stp
+
+	// Undocumented NMOS 6502
+	slo
+	rla
+	sre
+	rra
+	sax
+	lax
+	dcp
+	isc
+	anc
+	alr
+	arr
+	sbx
+	las
 )
 
 var instructionNames = [...]string{
@@ -154,6 +191,37 @@ var instructionNames = [...]string{
 	"TXA",
 	"TXS",
 	"TYA",
+
+	// 65C02
+	"BRA",
+	"PHX",
+	"PHY",
+	"PLX",
+	"PLY",
+	"STZ",
+	"TRB",
+	"TSB",
+	"RMB",
+	"SMB",
+	"BBR",
+	"BBS",
+	"WAI",
+	"STP",
+
+	// Undocumented NMOS 6502
+	"SLO",
+	"RLA",
+	"SRE",
+	"RRA",
+	"SAX",
+	"LAX",
+	"DCP",
+	"ISC",
+	"ANC",
+	"ALR",
+	"ARR",
+	"SBX",
+	"LAS",
 }
 
 // OpType is the operation type, it includes the instruction and
@@ -404,3 +472,286 @@ var opTypes = map[uint8]OpType{
 	// RTI
 	0x40: OpType{0x40, rti, implied, 1, 6},
 }
+
+// Instructions and addressing modes added by the WDC 65C02, on top
+// of those in opTypes.
+var wdc65C02OpTypes = map[uint8]OpType{
+	// BRA
+	0x80: OpType{0x80, bra, relative, 2, 2},
+
+	// PHX / PLX
+	0xDA: OpType{0xDA, phx, implied, 1, 3},
+	0xFA: OpType{0xFA, plx, implied, 1, 4},
+
+	// PHY / PLY
+	0x5A: OpType{0x5A, phy, implied, 1, 3},
+	0x7A: OpType{0x7A, ply, implied, 1, 4},
+
+	// STZ
+	0x64: OpType{0x64, stz, zeropage, 2, 3},
+	0x74: OpType{0x74, stz, zeropageX, 2, 4},
+	0x9C: OpType{0x9C, stz, absolute, 3, 4},
+	0x9E: OpType{0x9E, stz, absoluteX, 3, 5},
+
+	// TRB / TSB
+	0x14: OpType{0x14, trb, zeropage, 2, 5},
+	0x1C: OpType{0x1C, trb, absolute, 3, 6},
+	0x04: OpType{0x04, tsb, zeropage, 2, 5},
+	0x0C: OpType{0x0C, tsb, absolute, 3, 6},
+
+	// INC A / DEC A
+	0x1A: OpType{0x1A, inc, accumulator, 1, 2},
+	0x3A: OpType{0x3A, dec, accumulator, 1, 2},
+
+	// BIT
+	0x89: OpType{0x89, bit, immediate, 2, 2},
+	0x34: OpType{0x34, bit, zeropageX, 2, 4},
+	0x3C: OpType{0x3C, bit, absoluteX, 3, 4},
+
+	// (zeropage) addressing
+	0x12: OpType{0x12, ora, zeropageIndirect, 2, 5},
+	0x32: OpType{0x32, and, zeropageIndirect, 2, 5},
+	0x52: OpType{0x52, eor, zeropageIndirect, 2, 5},
+	0x72: OpType{0x72, adc, zeropageIndirect, 2, 5},
+	0x92: OpType{0x92, sta, zeropageIndirect, 2, 5},
+	0xB2: OpType{0xB2, lda, zeropageIndirect, 2, 5},
+	0xD2: OpType{0xD2, cmp, zeropageIndirect, 2, 5},
+	0xF2: OpType{0xF2, sbc, zeropageIndirect, 2, 5},
+
+	// JMP (absolute) takes an extra cycle to fix the page wrap bug
+	0x6C: OpType{0x6C, jmp, indirect, 3, 6},
+
+	// JMP (absolute,X)
+	0x7C: OpType{0x7C, jmp, absoluteIndirectX, 3, 6},
+
+	// WAI / STP
+	0xCB: OpType{0xCB, wai, implied, 1, 3},
+	0xDB: OpType{0xDB, stp, implied, 1, 3},
+
+	// RMB0-7
+	0x07: OpType{0x07, rmb, zeropage, 2, 5},
+	0x17: OpType{0x17, rmb, zeropage, 2, 5},
+	0x27: OpType{0x27, rmb, zeropage, 2, 5},
+	0x37: OpType{0x37, rmb, zeropage, 2, 5},
+	0x47: OpType{0x47, rmb, zeropage, 2, 5},
+	0x57: OpType{0x57, rmb, zeropage, 2, 5},
+	0x67: OpType{0x67, rmb, zeropage, 2, 5},
+	0x77: OpType{0x77, rmb, zeropage, 2, 5},
+
+	// SMB0-7
+	0x87: OpType{0x87, smb, zeropage, 2, 5},
+	0x97: OpType{0x97, smb, zeropage, 2, 5},
+	0xA7: OpType{0xA7, smb, zeropage, 2, 5},
+	0xB7: OpType{0xB7, smb, zeropage, 2, 5},
+	0xC7: OpType{0xC7, smb, zeropage, 2, 5},
+	0xD7: OpType{0xD7, smb, zeropage, 2, 5},
+	0xE7: OpType{0xE7, smb, zeropage, 2, 5},
+	0xF7: OpType{0xF7, smb, zeropage, 2, 5},
+
+	// BBR0-7
+	0x0F: OpType{0x0F, bbr, zeropageRelative, 3, 5},
+	0x1F: OpType{0x1F, bbr, zeropageRelative, 3, 5},
+	0x2F: OpType{0x2F, bbr, zeropageRelative, 3, 5},
+	0x3F: OpType{0x3F, bbr, zeropageRelative, 3, 5},
+	0x4F: OpType{0x4F, bbr, zeropageRelative, 3, 5},
+	0x5F: OpType{0x5F, bbr, zeropageRelative, 3, 5},
+	0x6F: OpType{0x6F, bbr, zeropageRelative, 3, 5},
+	0x7F: OpType{0x7F, bbr, zeropageRelative, 3, 5},
+
+	// BBS0-7
+	0x8F: OpType{0x8F, bbs, zeropageRelative, 3, 5},
+	0x9F: OpType{0x9F, bbs, zeropageRelative, 3, 5},
+	0xAF: OpType{0xAF, bbs, zeropageRelative, 3, 5},
+	0xBF: OpType{0xBF, bbs, zeropageRelative, 3, 5},
+	0xCF: OpType{0xCF, bbs, zeropageRelative, 3, 5},
+	0xDF: OpType{0xDF, bbs, zeropageRelative, 3, 5},
+	0xEF: OpType{0xEF, bbs, zeropageRelative, 3, 5},
+	0xFF: OpType{0xFF, bbs, zeropageRelative, 3, 5},
+
+	// ASL / LSR / ROL / ROR absolute,X take an extra cycle only when
+	// crossing a page boundary
+	0x1E: OpType{0x1E, asl, absoluteX, 3, 6},
+	0x5E: OpType{0x5E, lsr, absoluteX, 3, 6},
+	0x3E: OpType{0x3E, rol, absoluteX, 3, 6},
+	0x7E: OpType{0x7E, ror, absoluteX, 3, 6},
+
+	// All undefined opcodes are NOPs
+	0x02: OpType{0x02, nop, immediate, 2, 2},
+	0x22: OpType{0x22, nop, immediate, 2, 2},
+	0x42: OpType{0x42, nop, immediate, 2, 2},
+	0x62: OpType{0x62, nop, immediate, 2, 2},
+	0x82: OpType{0x82, nop, immediate, 2, 2},
+	0xC2: OpType{0xC2, nop, immediate, 2, 2},
+	0xE2: OpType{0xE2, nop, immediate, 2, 2},
+	0x44: OpType{0x44, nop, zeropage, 2, 3},
+	0x54: OpType{0x54, nop, zeropageX, 2, 4},
+	0xD4: OpType{0xD4, nop, zeropageX, 2, 4},
+	0xF4: OpType{0xF4, nop, zeropageX, 2, 4},
+	0x5C: OpType{0x5C, nop, absolute, 3, 8},
+	0xDC: OpType{0xDC, nop, absolute, 3, 4},
+	0xFC: OpType{0xFC, nop, absolute, 3, 4},
+
+	0x03: OpType{0x03, nop, implied, 1, 1},
+	0x13: OpType{0x13, nop, implied, 1, 1},
+	0x23: OpType{0x23, nop, implied, 1, 1},
+	0x33: OpType{0x33, nop, implied, 1, 1},
+	0x43: OpType{0x43, nop, implied, 1, 1},
+	0x53: OpType{0x53, nop, implied, 1, 1},
+	0x63: OpType{0x63, nop, implied, 1, 1},
+	0x73: OpType{0x73, nop, implied, 1, 1},
+	0x83: OpType{0x83, nop, implied, 1, 1},
+	0x93: OpType{0x93, nop, implied, 1, 1},
+	0xA3: OpType{0xA3, nop, implied, 1, 1},
+	0xB3: OpType{0xB3, nop, implied, 1, 1},
+	0xC3: OpType{0xC3, nop, implied, 1, 1},
+	0xD3: OpType{0xD3, nop, implied, 1, 1},
+	0xE3: OpType{0xE3, nop, implied, 1, 1},
+	0xF3: OpType{0xF3, nop, implied, 1, 1},
+
+	0x0B: OpType{0x0B, nop, implied, 1, 1},
+	0x1B: OpType{0x1B, nop, implied, 1, 1},
+	0x2B: OpType{0x2B, nop, implied, 1, 1},
+	0x3B: OpType{0x3B, nop, implied, 1, 1},
+	0x4B: OpType{0x4B, nop, implied, 1, 1},
+	0x5B: OpType{0x5B, nop, implied, 1, 1},
+	0x6B: OpType{0x6B, nop, implied, 1, 1},
+	0x7B: OpType{0x7B, nop, implied, 1, 1},
+	0x8B: OpType{0x8B, nop, implied, 1, 1},
+	0x9B: OpType{0x9B, nop, implied, 1, 1},
+	0xAB: OpType{0xAB, nop, implied, 1, 1},
+	0xBB: OpType{0xBB, nop, implied, 1, 1},
+	0xEB: OpType{0xEB, nop, implied, 1, 1},
+	0xFB: OpType{0xFB, nop, implied, 1, 1},
+}
+
+// Stable undocumented instructions of the NMOS 6502, on top of those
+// in opTypes. Unstable instructions, like XAA and SHA, are left out.
+var mos6502UndocumentedOpTypes = map[uint8]OpType{
+	// SLO
+	0x07: OpType{0x07, slo, zeropage, 2, 5},
+	0x17: OpType{0x17, slo, zeropageX, 2, 6},
+	0x0F: OpType{0x0F, slo, absolute, 3, 6},
+	0x1F: OpType{0x1F, slo, absoluteX, 3, 7},
+	0x1B: OpType{0x1B, slo, absoluteY, 3, 7},
+	0x03: OpType{0x03, slo, indirectX, 2, 8},
+	0x13: OpType{0x13, slo, indirectY, 2, 8},
+
+	// RLA
+	0x27: OpType{0x27, rla, zeropage, 2, 5},
+	0x37: OpType{0x37, rla, zeropageX, 2, 6},
+	0x2F: OpType{0x2F, rla, absolute, 3, 6},
+	0x3F: OpType{0x3F, rla, absoluteX, 3, 7},
+	0x3B: OpType{0x3B, rla, absoluteY, 3, 7},
+	0x23: OpType{0x23, rla, indirectX, 2, 8},
+	0x33: OpType{0x33, rla, indirectY, 2, 8},
+
+	// SRE
+	0x47: OpType{0x47, sre, zeropage, 2, 5},
+	0x57: OpType{0x57, sre, zeropageX, 2, 6},
+	0x4F: OpType{0x4F, sre, absolute, 3, 6},
+	0x5F: OpType{0x5F, sre, absoluteX, 3, 7},
+	0x5B: OpType{0x5B, sre, absoluteY, 3, 7},
+	0x43: OpType{0x43, sre, indirectX, 2, 8},
+	0x53: OpType{0x53, sre, indirectY, 2, 8},
+
+	// RRA
+	0x67: OpType{0x67, rra, zeropage, 2, 5},
+	0x77: OpType{0x77, rra, zeropageX, 2, 6},
+	0x6F: OpType{0x6F, rra, absolute, 3, 6},
+	0x7F: OpType{0x7F, rra, absoluteX, 3, 7},
+	0x7B: OpType{0x7B, rra, absoluteY, 3, 7},
+	0x63: OpType{0x63, rra, indirectX, 2, 8},
+	0x73: OpType{0x73, rra, indirectY, 2, 8},
+
+	// DCP
+	0xC7: OpType{0xC7, dcp, zeropage, 2, 5},
+	0xD7: OpType{0xD7, dcp, zeropageX, 2, 6},
+	0xCF: OpType{0xCF, dcp, absolute, 3, 6},
+	0xDF: OpType{0xDF, dcp, absoluteX, 3, 7},
+	0xDB: OpType{0xDB, dcp, absoluteY, 3, 7},
+	0xC3: OpType{0xC3, dcp, indirectX, 2, 8},
+	0xD3: OpType{0xD3, dcp, indirectY, 2, 8},
+
+	// ISC
+	0xE7: OpType{0xE7, isc, zeropage, 2, 5},
+	0xF7: OpType{0xF7, isc, zeropageX, 2, 6},
+	0xEF: OpType{0xEF, isc, absolute, 3, 6},
+	0xFF: OpType{0xFF, isc, absoluteX, 3, 7},
+	0xFB: OpType{0xFB, isc, absoluteY, 3, 7},
+	0xE3: OpType{0xE3, isc, indirectX, 2, 8},
+	0xF3: OpType{0xF3, isc, indirectY, 2, 8},
+
+	// SAX
+	0x87: OpType{0x87, sax, zeropage, 2, 3},
+	0x97: OpType{0x97, sax, zeropageY, 2, 4},
+	0x8F: OpType{0x8F, sax, absolute, 3, 4},
+	0x83: OpType{0x83, sax, indirectX, 2, 6},
+
+	// LAX
+	0xA7: OpType{0xA7, lax, zeropage, 2, 3},
+	0xB7: OpType{0xB7, lax, zeropageY, 2, 4},
+	0xAF: OpType{0xAF, lax, absolute, 3, 4},
+	0xBF: OpType{0xBF, lax, absoluteY, 3, 4},
+	0xA3: OpType{0xA3, lax, indirectX, 2, 6},
+	0xB3: OpType{0xB3, lax, indirectY, 2, 5},
+
+	// LAS
+	0xBB: OpType{0xBB, las, absoluteY, 3, 4},
+
+	// Immediate ANC, ALR, ARR, SBX and SBC
+	0x0B: OpType{0x0B, anc, immediate, 2, 2},
+	0x2B: OpType{0x2B, anc, immediate, 2, 2},
+	0x4B: OpType{0x4B, alr, immediate, 2, 2},
+	0x6B: OpType{0x6B, arr, immediate, 2, 2},
+	0xCB: OpType{0xCB, sbx, immediate, 2, 2},
+	0xEB: OpType{0xEB, sbc, immediate, 2, 2},
+
+	// NOPs
+	0x1A: OpType{0x1A, nop, implied, 1, 2},
+	0x3A: OpType{0x3A, nop, implied, 1, 2},
+	0x5A: OpType{0x5A, nop, implied, 1, 2},
+	0x7A: OpType{0x7A, nop, implied, 1, 2},
+	0xDA: OpType{0xDA, nop, implied, 1, 2},
+	0xFA: OpType{0xFA, nop, implied, 1, 2},
+	0x80: OpType{0x80, nop, immediate, 2, 2},
+	0x82: OpType{0x82, nop, immediate, 2, 2},
+	0x89: OpType{0x89, nop, immediate, 2, 2},
+	0xC2: OpType{0xC2, nop, immediate, 2, 2},
+	0xE2: OpType{0xE2, nop, immediate, 2, 2},
+	0x04: OpType{0x04, nop, zeropage, 2, 3},
+	0x44: OpType{0x44, nop, zeropage, 2, 3},
+	0x64: OpType{0x64, nop, zeropage, 2, 3},
+	0x14: OpType{0x14, nop, zeropageX, 2, 4},
+	0x34: OpType{0x34, nop, zeropageX, 2, 4},
+	0x54: OpType{0x54, nop, zeropageX, 2, 4},
+	0x74: OpType{0x74, nop, zeropageX, 2, 4},
+	0xD4: OpType{0xD4, nop, zeropageX, 2, 4},
+	0xF4: OpType{0xF4, nop, zeropageX, 2, 4},
+	0x0C: OpType{0x0C, nop, absolute, 3, 4},
+	0x1C: OpType{0x1C, nop, absoluteX, 3, 4},
+	0x3C: OpType{0x3C, nop, absoluteX, 3, 4},
+	0x5C: OpType{0x5C, nop, absoluteX, 3, 4},
+	0x7C: OpType{0x7C, nop, absoluteX, 3, 4},
+	0xDC: OpType{0xDC, nop, absoluteX, 3, 4},
+	0xFC: OpType{0xFC, nop, absoluteX, 3, 4},
+}
+
+// Instruction sets, by Cpu variant
+var instructionSets = map[Variant]map[uint8]OpType{
+	Mos6502:             opTypes,
+	Wdc65C02:            mergeOpTypes(opTypes, wdc65C02OpTypes),
+	Mos6502Undocumented: mergeOpTypes(opTypes, mos6502UndocumentedOpTypes),
+}
+
+// Combine OpType tables, later tables override earlier ones.
+func mergeOpTypes(tables ...map[uint8]OpType) map[uint8]OpType {
+	merged := make(map[uint8]OpType)
+
+	for _, table := range tables {
+		for opcode, optype := range table {
+			merged[opcode] = optype
+		}
+	}
+
+	return merged
+}
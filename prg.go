@@ -0,0 +1,27 @@
+package i6502
+
+import "fmt"
+
+/*
+A Commodore PRG file starts with the 16-bit load address, low byte
+first, followed by the program data.
+*/
+type Prg struct {
+	LoadAddress uint16 // Address the program is loaded at
+	Data        []byte // Program data, without the load address
+}
+
+// Parse the content of a PRG file.
+func ParsePrg(data []byte) (*Prg, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("PRG file is missing the load address")
+	}
+
+	prg := &Prg{LoadAddress: uint16(data[1])<<8 | uint16(data[0]), Data: data[2:]}
+
+	if int(prg.LoadAddress)+len(prg.Data) > 0x10000 {
+		return nil, fmt.Errorf("PRG file of 0x%X bytes does not fit at 0x%04X", len(prg.Data), prg.LoadAddress)
+	}
+
+	return prg, nil
+}
@@ -0,0 +1,32 @@
+package i6502
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrg(t *testing.T) {
+	prg, err := ParsePrg([]byte{0x01, 0x08, 0xA9, 0x42})
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0x0801, prg.LoadAddress)
+	assert.Equal(t, []byte{0xA9, 0x42}, prg.Data)
+
+	_, err = ParsePrg([]byte{0x01})
+	assert.NotNil(t, err)
+
+	_, err = ParsePrg([]byte{0xFF, 0xFF, 0x01, 0x02})
+	assert.NotNil(t, err)
+}
+
+func TestCpuLoadPrg(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	prg, _ := ParsePrg([]byte{0x00, 0xC0, 0xA9, 0x42})
+
+	assert.Nil(t, cpu.LoadPrg(prg))
+	assert.EqualValues(t, 0xC000, cpu.PC)
+
+	step(t, cpu)
+	assert.EqualValues(t, 0x42, cpu.A)
+}
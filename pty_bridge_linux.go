@@ -0,0 +1,230 @@
+package i6502
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// How often the bridge checks whether a program has the pseudo-terminal open
+const ptyPollInterval = 20 * time.Millisecond
+
+const pollHup = 0x10
+
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+/*
+PtyBridge makes an Acia6551 available as a Linux pseudo-terminal, like
+/dev/pts/3, for terminal programs like minicom, or XMODEM tools:
+
+	bridge, err := i6502.NewPtyBridge(acia)
+	fmt.Println("Serial port at", bridge.Name())
+
+The pseudo-terminal is in raw mode, so data is passed on unchanged.
+
+The modem control lines are emulated: DSR and DCD are asserted while a
+program has the pseudo-terminal open. Data only flows while the Cpu
+asserts DTR in the command register. Output is held until then, input
+is lost.
+*/
+type PtyBridge struct {
+	acia   *Acia6551
+	master *os.File
+	name   string
+
+	mutex   sync.Mutex
+	closing chan struct{} // Closed with the bridge, to stop reading from the ACIA
+}
+
+// Create a new pseudo-terminal for acia.
+func NewPtyBridge(acia *Acia6551) (*PtyBridge, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var number uint32
+	unlock := int32(0)
+
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&number)); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	bridge := &PtyBridge{acia: acia, master: master, name: fmt.Sprintf("/dev/pts/%d", number), closing: make(chan struct{})}
+
+	if err := bridge.makeRaw(); err != nil {
+		master.Close()
+		return nil, err
+	}
+
+	bridge.updateModemLines()
+
+	go bridge.input()
+	go bridge.output()
+	go bridge.monitor()
+
+	return bridge, nil
+}
+
+// Returns the path of the pseudo-terminal, like /dev/pts/3.
+func (b *PtyBridge) Name() string {
+	return b.name
+}
+
+// Remove the pseudo-terminal. The ACIA is left open, data the terminal has
+// not received yet stays in its buffer.
+func (b *PtyBridge) Close() error {
+	b.mutex.Lock()
+	if !b.isClosed() {
+		close(b.closing)
+	}
+	b.mutex.Unlock()
+
+	return b.master.Close()
+}
+
+func (b *PtyBridge) isClosed() bool {
+	return isCancelled(b.closing)
+}
+
+// Disables line editing, echo and all character translation on the
+// pseudo-terminal.
+func (b *PtyBridge) makeRaw() error {
+	slave, err := os.OpenFile(b.name, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return err
+	}
+	defer slave.Close()
+
+	var termios syscall.Termios
+	if err := ioctl(slave, syscall.TCGETS, unsafe.Pointer(&termios)); err != nil {
+		return err
+	}
+
+	termios.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	termios.Oflag &^= syscall.OPOST
+	termios.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	termios.Cflag &^= syscall.CSIZE | syscall.PARENB
+	termios.Cflag |= syscall.CS8
+	termios.Cc[syscall.VMIN] = 1
+	termios.Cc[syscall.VTIME] = 0
+
+	return ioctl(slave, syscall.TCSETS, unsafe.Pointer(&termios))
+}
+
+// Copies data from the pseudo-terminal to the ACIA.
+func (b *PtyBridge) input() {
+	buffer := make([]byte, 256)
+
+	for {
+		n, err := b.master.Read(buffer)
+		if n > 0 && b.acia.DTR() {
+			b.acia.Write(buffer[:n])
+		}
+
+		if err != nil {
+			if b.isClosed() {
+				return
+			}
+
+			// No program has the pseudo-terminal open
+			time.Sleep(ptyPollInterval)
+		}
+	}
+}
+
+// Copies data from the ACIA to the pseudo-terminal, while DTR is asserted,
+// until the bridge is closed.
+func (b *PtyBridge) output() {
+	buffer := make([]byte, 256)
+
+	for {
+		for !b.acia.DTR() {
+			if b.isClosed() {
+				return
+			}
+			time.Sleep(ptyPollInterval)
+		}
+
+		n, err := b.acia.read(buffer, b.closing)
+		if err != nil {
+			return
+		}
+
+		if _, err := b.master.Write(buffer[:n]); err != nil && b.isClosed() {
+			return
+		}
+	}
+}
+
+// Keeps DSR and DCD up to date, until the bridge is closed.
+func (b *PtyBridge) monitor() {
+	for !b.isClosed() {
+		b.updateModemLines()
+		time.Sleep(ptyPollInterval)
+	}
+}
+
+// Asserts DSR and DCD while a program has the pseudo-terminal open.
+func (b *PtyBridge) updateModemLines() {
+	connected := !b.hungUp()
+
+	b.acia.SetDSR(connected)
+	b.acia.SetDCD(connected)
+}
+
+// Returns true when no program has the pseudo-terminal open.
+func (b *PtyBridge) hungUp() bool {
+	raw, err := b.master.SyscallConn()
+	if err != nil {
+		return true
+	}
+
+	hungUp := true
+	raw.Control(func(fd uintptr) {
+		poll := pollFd{fd: int32(fd)}
+		timeout := syscall.Timespec{}
+
+		_, _, errno := syscall.Syscall6(syscall.SYS_PPOLL, uintptr(unsafe.Pointer(&poll)), 1, uintptr(unsafe.Pointer(&timeout)), 0, 0, 0)
+		if errno == 0 {
+			hungUp = poll.revents&pollHup != 0
+		}
+	})
+
+	return hungUp
+}
+
+// Performs an ioctl on file, without putting it in blocking mode.
+func ioctl(file *os.File, request uintptr, argument unsafe.Pointer) error {
+	raw, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errno syscall.Errno
+	err = raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(argument))
+	})
+	if err != nil {
+		return err
+	}
+
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
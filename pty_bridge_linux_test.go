@@ -0,0 +1,101 @@
+package i6502
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Waits until the status register of the ACIA matches value, for the bits in mask
+func waitForStatus(a *Acia6551, mask, value byte) bool {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if a.Peek(aciaStatus)&mask == value {
+			return true
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return false
+}
+
+func TestPtyBridge(t *testing.T) {
+	acia, _ := NewAcia6551(nil)
+	defer acia.Close()
+
+	bridge, err := NewPtyBridge(acia)
+	if err != nil {
+		t.Skip("Pseudo-terminals are not available:", err)
+	}
+	defer bridge.Close()
+
+	assert.True(t, strings.HasPrefix(bridge.Name(), "/dev/pts/"))
+
+	// Nobody has the terminal open: no carrier, data set not ready
+	assert.True(t, waitForStatus(acia, 0x60, 0x60))
+
+	terminal, err := os.OpenFile(bridge.Name(), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.True(t, waitForStatus(acia, 0x60, 0x00))
+
+	// Data flows once the Cpu asserts DTR
+	acia.WriteByte(aciaCommand, 0x09)
+	acia.WriteByte(aciaData, '\n')
+
+	output := make([]byte, 1)
+	_, err = terminal.Read(output)
+	assert.Nil(t, err)
+	assert.EqualValues(t, '\n', output[0])
+
+	terminal.Write([]byte{'\r'})
+	assert.True(t, waitForStatus(acia, 0x08, 0x08))
+	assert.EqualValues(t, '\r', acia.ReadByte(aciaData))
+
+	terminal.Close()
+	assert.True(t, waitForStatus(acia, 0x60, 0x60))
+}
+
+func TestPtyBridgeReplaced(t *testing.T) {
+	acia, _ := NewAcia6551(nil)
+	defer acia.Close()
+	acia.WriteByte(aciaCommand, 0x09)
+
+	bridge, err := NewPtyBridge(acia)
+	if err != nil {
+		t.Skip("Pseudo-terminals are not available:", err)
+	}
+
+	// The bridge is waiting for output
+	terminal, _ := os.OpenFile(bridge.Name(), os.O_RDWR|syscall.O_NOCTTY, 0)
+	terminal.SetReadDeadline(time.Now().Add(5 * time.Second))
+	acia.WriteByte(aciaData, 'A')
+	output := make([]byte, 4)
+	_, err = io.ReadFull(terminal, output[:1])
+	assert.Nil(t, err)
+
+	terminal.Close()
+	bridge.Close()
+
+	// Output after closing is left for the next bridge
+	for _, b := range []byte("XYZW") {
+		acia.WriteByte(aciaData, b)
+	}
+
+	bridge, _ = NewPtyBridge(acia)
+	defer bridge.Close()
+	terminal, _ = os.OpenFile(bridge.Name(), os.O_RDWR|syscall.O_NOCTTY, 0)
+	defer terminal.Close()
+	terminal.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	_, err = io.ReadFull(terminal, output)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("XYZW"), output)
+}
@@ -7,6 +7,9 @@ import (
 
 /*
 Read-Only Memory
+
+Writing to Rom panics. When attached to the AddressBus, writes are
+reported as a BusFaultError instead.
 */
 type Rom struct {
 	data []byte
@@ -25,6 +28,15 @@ func NewRom(path string) (*Rom, error) {
 	return &Rom{data: data}, nil
 }
 
+// Create a new Rom component with a copy of the given content.
+func NewRomFromBytes(data []byte) (*Rom, error) {
+	if len(data) == 0 || len(data) > 0x10000 {
+		return nil, fmt.Errorf("Rom of 0x%X bytes does not fit in the 16-bit address space", len(data))
+	}
+
+	return &Rom{data: append([]byte{}, data...)}, nil
+}
+
 func (r *Rom) Size() uint16 {
 	return uint16(len(r.data))
 }
@@ -36,3 +48,12 @@ func (r *Rom) ReadByte(address uint16) byte {
 func (r *Rom) WriteByte(address uint16, data byte) {
 	panic(fmt.Errorf("Trying to write to ROM at 0x%04X", address))
 }
+
+func (r *Rom) ReadOnly() bool {
+	return true
+}
+
+// Change the content of the Rom, for debuggers and patching.
+func (r *Rom) Poke(address uint16, data byte) {
+	r.data[address] = data
+}
@@ -42,3 +42,25 @@ func TestRomNotFound(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Nil(t, rom)
 }
+
+func TestRomPoke(t *testing.T) {
+	rom, _ := NewRom("test/8kb.rom")
+
+	rom.Poke(0x1337, 0x42)
+	assert.EqualValues(t, 0x42, rom.ReadByte(0x1337))
+}
+
+func TestRomFromBytes(t *testing.T) {
+	data := []byte{0x01, 0x02}
+	rom, err := NewRomFromBytes(data)
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0x02, rom.Size())
+
+	// The Rom has its own copy
+	rom.Poke(0x0000, 0x42)
+	assert.EqualValues(t, 0x01, data[0])
+
+	_, err = NewRomFromBytes(nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,107 @@
+package i6502
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+Load a Motorola S-record file (S19, S28 or S37) into memory, at the
+addresses in the file.
+
+Header (S0) and count (S5, S6) records are skipped. Termination records
+(S7, S8, S9) set Program.Start. Toolchains write a start address of 0 when
+there is no entry point, so 0 is not taken as a start address.
+
+A LoadError is returned for malformed records, checksum mismatches and
+data outside the 16-bit address space. Data before the error has already
+been written.
+*/
+func LoadSRecord(r io.Reader, memory MemoryWriter) (*Program, error) {
+	program := &Program{}
+	line := 0
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		if err := loadSRecord(text, memory, program); err != nil {
+			return nil, &LoadError{Format: "S-record", Line: line, Err: err}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return program, nil
+}
+
+// Loads a single S-record
+func loadSRecord(text string, memory MemoryWriter, program *Program) error {
+	if len(text) < 2 || text[0] != 'S' {
+		return fmt.Errorf("Record does not start with 'S'")
+	}
+
+	record, err := hex.DecodeString(text[2:])
+	if err != nil {
+		return fmt.Errorf("Invalid hex digits")
+	}
+
+	if len(record) < 1 || len(record) != int(record[0])+1 {
+		return fmt.Errorf("Invalid record length")
+	}
+
+	if sum := checksum(record[:len(record)-1]); ^sum != record[len(record)-1] {
+		return fmt.Errorf("Checksum 0x%02X does not match 0x%02X", record[len(record)-1], ^sum)
+	}
+
+	var addressLength int
+
+	switch text[1] {
+	case '0', '1', '5', '9':
+		addressLength = 2
+	case '2', '6', '8':
+		addressLength = 3
+	case '3', '7':
+		addressLength = 4
+	default:
+		return fmt.Errorf("Unknown record type S%c", text[1])
+	}
+
+	if len(record) < addressLength+2 {
+		return fmt.Errorf("Invalid record length")
+	}
+
+	address := uint32(0)
+	for _, b := range record[1 : addressLength+1] {
+		address = address<<8 | uint32(b)
+	}
+	data := record[addressLength+1 : len(record)-1]
+
+	switch text[1] {
+	case '1', '2', '3':
+		if err := writeData(memory, address, data); err != nil {
+			return err
+		}
+		program.Bytes += len(data)
+	case '7', '8', '9':
+		if address > 0xFFFF {
+			return fmt.Errorf("Start address 0x%X does not fit in the 16-bit address space", address)
+		}
+		if address != 0 {
+			program.Start = uint16(address)
+			program.HasStart = true
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package i6502
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSRecord(t *testing.T) {
+	ram, _ := NewRam(0x10000)
+	file := strings.Join([]string{
+		"S00600004844521B",
+		"S1080200A9428D00037A",
+		"S2060010000102E6",
+		"S5030001FB",
+		"S9030200FA",
+	}, "\n")
+
+	program, err := LoadSRecord(strings.NewReader(file), ram)
+
+	assert.Nil(t, err)
+	assert.Equal(t, &Program{Start: 0x0200, HasStart: true, Bytes: 7}, program)
+	assert.EqualValues(t, 0xA9, ram.ReadByte(0x0200))
+	assert.EqualValues(t, 0x03, ram.ReadByte(0x0204))
+	assert.EqualValues(t, 0x01, ram.ReadByte(0x1000))
+	assert.EqualValues(t, 0x02, ram.ReadByte(0x1001))
+}
+
+func TestLoadSRecordErrors(t *testing.T) {
+	ram, _ := NewRam(0x10000)
+
+	for file, message := range map[string]string{
+		"S1080200A9428D00037B":           "S-record line 1: Checksum 0x7B does not match 0x7A",
+		"S00600004844521B\n:0200":        "S-record line 2: Record does not start with 'S'",
+		"S1080200A9428D0003":             "S-record line 1: Invalid record length",
+		"S4030001FB":                     "S-record line 1: Unknown record type S4",
+		"S1080200A9428D0Z037A":           "S-record line 1: Invalid hex digits",
+		"S2060100000102F5":               "S-record line 1: Data at 0x10000 does not fit in the 16-bit address space",
+		"S00600004844521B\n\nS1080200A9": "S-record line 3: Invalid record length",
+	} {
+		program, err := LoadSRecord(strings.NewReader(file), ram)
+
+		assert.Nil(t, program)
+		if assert.NotNil(t, err, file) {
+			assert.Equal(t, message, err.Error())
+		}
+	}
+}
+
+func TestCpuLoadSRecord(t *testing.T) {
+	cpu, _, _ := NewRamMachine()
+	file := "S1080200A9428D00037A\nS9030200FA\n"
+
+	cpu.PC = 0x1234
+	assert.Nil(t, cpu.LoadSRecord(strings.NewReader(file)))
+	assert.EqualValues(t, 0x0200, cpu.PC)
+	assert.EqualValues(t, 0xA9, cpu.Bus.ReadByte(0x0200))
+
+	// Start address 0 means no entry point
+	cpu.PC = 0x1234
+	assert.Nil(t, cpu.LoadSRecord(strings.NewReader("S1080200A9428D00037A\nS9030000FC\n")))
+	assert.EqualValues(t, 0x1234, cpu.PC)
+}
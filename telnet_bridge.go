@@ -0,0 +1,266 @@
+package i6502
+
+import (
+	"net"
+	"sync"
+)
+
+// Telnet commands and options
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWill = 251
+	telnetWont = 252
+	telnetDo   = 253
+	telnetDont = 254
+	telnetIAC  = 255
+
+	telnetEcho            = 1
+	telnetSuppressGoAhead = 3
+	telnetLinemode        = 34
+)
+
+// Sent to every client: the bridge echoes and works in character mode, so
+// the client sends every key press without echoing it locally.
+var telnetNegotiation = []byte{
+	telnetIAC, telnetWill, telnetEcho,
+	telnetIAC, telnetWill, telnetSuppressGoAhead,
+	telnetIAC, telnetDont, telnetLinemode,
+}
+
+/*
+TelnetBridge exposes an Acia6551 on a TCP listener, to use it as a serial
+console from a telnet client:
+
+	bridge, err := i6502.NewTelnetBridge(acia, "localhost:6502")
+
+and in another terminal:
+
+	telnet localhost 6502
+
+Clients are served one at a time. When a client disconnects, the next one
+can connect. Output from the ACIA is only read while a client is
+connected, so it is kept in the ACIA buffer in the meantime, or until
+another bridge takes over.
+
+Telnet commands from the client are filtered out. Echoing is left to the
+emulated machine, as with a real serial terminal.
+*/
+type TelnetBridge struct {
+	acia     *Acia6551
+	listener net.Listener
+
+	mutex      sync.Mutex
+	connected  *sync.Cond    // Signalled when a client connects, or the bridge is closed
+	connection net.Conn      // Current client, nil if none
+	closing    chan struct{} // Closed with the bridge, to stop reading from the ACIA
+	closed     bool
+}
+
+// Create a new TelnetBridge for acia, listening on the TCP address.
+func NewTelnetBridge(acia *Acia6551, address string) (*TelnetBridge, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	bridge := &TelnetBridge{acia: acia, listener: listener, closing: make(chan struct{})}
+	bridge.connected = sync.NewCond(&bridge.mutex)
+
+	go bridge.accept()
+	go bridge.output()
+
+	return bridge, nil
+}
+
+// Returns the address the bridge is listening on.
+func (b *TelnetBridge) Addr() net.Addr {
+	return b.listener.Addr()
+}
+
+// Stop listening and disconnect the client. The ACIA is left open, data
+// the client has not received yet stays in its buffer.
+func (b *TelnetBridge) Close() error {
+	b.mutex.Lock()
+	if !b.closed {
+		close(b.closing)
+	}
+	b.closed = true
+	if b.connection != nil {
+		b.connection.Close()
+	}
+	b.connected.Broadcast()
+	b.mutex.Unlock()
+
+	return b.listener.Close()
+}
+
+// Serves clients one at a time, until the bridge is closed.
+func (b *TelnetBridge) accept() {
+	for {
+		connection, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		if _, err := connection.Write(telnetNegotiation); err != nil {
+			connection.Close()
+			continue
+		}
+
+		b.mutex.Lock()
+		if b.closed {
+			b.mutex.Unlock()
+			connection.Close()
+			return
+		}
+		b.connection = connection
+		b.connected.Broadcast()
+		b.mutex.Unlock()
+
+		b.input(connection)
+
+		b.mutex.Lock()
+		b.connection = nil
+		b.mutex.Unlock()
+		connection.Close()
+	}
+}
+
+// Copies data from the client to the ACIA, until it disconnects.
+func (b *TelnetBridge) input(connection net.Conn) {
+	buffer := make([]byte, 256)
+	filter := &telnetFilter{}
+
+	for {
+		n, err := connection.Read(buffer)
+		if data := filter.filter(buffer[:n]); len(data) > 0 {
+			if _, err := b.acia.Write(data); err != nil {
+				return
+			}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Copies data from the ACIA to the connected client, until the bridge is
+// closed.
+func (b *TelnetBridge) output() {
+	buffer := make([]byte, 256)
+
+	for {
+		if b.waitForConnection() == nil {
+			return
+		}
+
+		n, err := b.acia.read(buffer, b.closing)
+		if err != nil {
+			return
+		}
+
+		connection := b.waitForConnection()
+		if connection == nil {
+			return
+		}
+
+		// Data is lost when the client disconnects while writing
+		connection.Write(telnetEscape(buffer[:n]))
+	}
+}
+
+// Returns the connected client, waiting for one if needed. Returns nil
+// when the bridge is closed.
+func (b *TelnetBridge) waitForConnection() net.Conn {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for b.connection == nil && !b.closed {
+		b.connected.Wait()
+	}
+
+	if b.closed {
+		return nil
+	}
+
+	return b.connection
+}
+
+// Doubles IAC bytes, so they're not taken for telnet commands.
+func telnetEscape(data []byte) []byte {
+	escaped := make([]byte, 0, len(data))
+
+	for _, b := range data {
+		escaped = append(escaped, b)
+		if b == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+	}
+
+	return escaped
+}
+
+// Removes telnet commands from the data sent by a client. Commands may be
+// split over multiple reads.
+type telnetFilter struct {
+	state int  // Position in the current command
+	cr    bool // Last data byte was a carriage return
+}
+
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSubnegotiation
+	telnetStateSubnegotiationIAC
+)
+
+func (f *telnetFilter) filter(data []byte) []byte {
+	filtered := make([]byte, 0, len(data))
+
+	for _, b := range data {
+		switch f.state {
+		case telnetStateData:
+			if b == telnetIAC {
+				f.state = telnetStateIAC
+				continue
+			}
+
+			// Enter is sent as CR NUL
+			if b == 0x00 && f.cr {
+				f.cr = false
+				continue
+			}
+
+			f.cr = b == '\r'
+			filtered = append(filtered, b)
+		case telnetStateIAC:
+			f.state = telnetStateData
+
+			switch b {
+			case telnetIAC:
+				filtered = append(filtered, b)
+			case telnetWill, telnetWont, telnetDo, telnetDont:
+				f.state = telnetStateOption
+			case telnetSB:
+				f.state = telnetStateSubnegotiation
+			}
+		case telnetStateOption:
+			f.state = telnetStateData
+		case telnetStateSubnegotiation:
+			if b == telnetIAC {
+				f.state = telnetStateSubnegotiationIAC
+			}
+		case telnetStateSubnegotiationIAC:
+			if b == telnetSE {
+				f.state = telnetStateData
+			} else {
+				f.state = telnetStateSubnegotiation
+			}
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,121 @@
+package i6502
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Connects to the bridge and checks the telnet negotiation
+func telnetConnect(t *testing.T, bridge *TelnetBridge) net.Conn {
+	client, err := net.Dial("tcp", bridge.Addr().String())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	negotiation := make([]byte, len(telnetNegotiation))
+	_, err = io.ReadFull(client, negotiation)
+	assert.Nil(t, err)
+	assert.Equal(t, telnetNegotiation, negotiation)
+
+	return client
+}
+
+// Lets the Cpu side of the ACIA receive the given number of bytes
+func aciaReceive(a *Acia6551, count int) []byte {
+	received := make([]byte, 0, count)
+	deadline := time.Now().Add(5 * time.Second)
+
+	for len(received) < count && time.Now().Before(deadline) {
+		if a.ReadByte(aciaStatus)&0x08 != 0 {
+			received = append(received, a.ReadByte(aciaData))
+		}
+		a.Tick(0x10)
+	}
+
+	return received
+}
+
+func TestTelnetBridge(t *testing.T) {
+	acia, _ := NewAcia6551(nil)
+	acia.WriteByte(aciaControl, 0x1F) // 19200 baud
+	defer acia.Close()
+
+	bridge, err := NewTelnetBridge(acia, "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer bridge.Close()
+
+	client := telnetConnect(t, bridge)
+
+	// Telnet commands are filtered, IAC IAC is data, CR NUL is CR
+	client.Write([]byte{'A', telnetIAC, telnetDo, telnetEcho, telnetIAC, telnetIAC})
+	client.Write([]byte{telnetIAC, telnetSB, telnetLinemode, 0x01, telnetIAC, telnetSE, '\r', 0x00, 'B'})
+	assert.Equal(t, []byte{'A', 0xFF, '\r', 'B'}, aciaReceive(acia, 4))
+
+	// Output is escaped
+	acia.WriteByte(aciaControl, 0x00)
+	acia.WriteByte(aciaData, 'C')
+	acia.WriteByte(aciaData, 0xFF)
+
+	output := make([]byte, 3)
+	_, err = io.ReadFull(client, output)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{'C', telnetIAC, telnetIAC}, output)
+
+	// Reconnect
+	client.Close()
+	client = telnetConnect(t, bridge)
+	defer client.Close()
+
+	acia.WriteByte(aciaData, 'D')
+	_, err = io.ReadFull(client, output[:1])
+	assert.Nil(t, err)
+	assert.EqualValues(t, 'D', output[0])
+
+	client.Write([]byte{'E'})
+	acia.WriteByte(aciaControl, 0x1F)
+	assert.Equal(t, []byte{'E'}, aciaReceive(acia, 1))
+}
+
+func TestTelnetBridgeReplaced(t *testing.T) {
+	acia, _ := NewAcia6551(nil)
+	defer acia.Close()
+
+	bridge, _ := NewTelnetBridge(acia, "127.0.0.1:0")
+	client := telnetConnect(t, bridge)
+
+	// The bridge is waiting for output
+	acia.WriteByte(aciaData, 'A')
+	output := make([]byte, 4)
+	_, err := io.ReadFull(client, output[:1])
+	assert.Nil(t, err)
+
+	client.Close()
+	bridge.Close()
+
+	// Output after closing is left for the next bridge
+	for _, b := range []byte("XYZW") {
+		acia.WriteByte(aciaData, b)
+	}
+
+	bridge, _ = NewTelnetBridge(acia, "127.0.0.1:0")
+	defer bridge.Close()
+	client = telnetConnect(t, bridge)
+	defer client.Close()
+
+	_, err = io.ReadFull(client, output)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("XYZW"), output)
+}
+
+func TestTelnetBridgeListenError(t *testing.T) {
+	acia, _ := NewAcia6551(nil)
+
+	bridge, err := NewTelnetBridge(acia, "127.0.0.1:-1")
+	assert.NotNil(t, err)
+	assert.Nil(t, bridge)
+}
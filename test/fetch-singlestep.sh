@@ -0,0 +1,17 @@
+#!/bin/sh
+# Fetches Tom Harte's SingleStepTests for the undocumented NMOS 6502
+# instructions, keeping the first 200 vectors of each opcode, into
+# test/65x02/6502/v1. Needs curl and jq.
+set -e
+
+url=https://raw.githubusercontent.com/SingleStepTests/65x02/main/6502/v1
+dir=$(dirname "$0")/65x02/6502/v1
+mkdir -p "$dir"
+
+# Opcodes in mos6502UndocumentedOpTypes
+for opcode in 03 04 07 0b 0c 0f 13 14 17 1a 1b 1c 1f 23 27 2b 2f 33 34 37 \
+	3a 3b 3c 3f 43 44 47 4b 4f 53 54 57 5a 5b 5c 5f 63 64 67 6b 6f 73 74 \
+	77 7a 7b 7c 7f 80 82 83 87 89 8f 97 a3 a7 af b3 b7 bb bf c2 c3 c7 cb \
+	cf d3 d4 d7 da db dc df e2 e3 e7 eb ef f3 f4 f7 fa fb fc ff; do
+	curl -sSf "$url/$opcode.json" | jq -c '.[:200]' > "$dir/$opcode.json"
+done
@@ -0,0 +1,826 @@
+package i6502
+
+import "sync"
+
+const (
+	viaORB = iota
+	viaORA
+	viaDDRB
+	viaDDRA
+	viaT1CL
+	viaT1CH
+	viaT1LL
+	viaT1LH
+	viaT2CL
+	viaT2CH
+	viaSR
+	viaACR
+	viaPCR
+	viaIFR
+	viaIER
+	viaORANoHandshake
+)
+
+// Interrupt flags, as found in the IFR and IER
+const (
+	viaIrqCA2 = 1 << iota
+	viaIrqCA1
+	viaIrqSR
+	viaIrqCB2
+	viaIrqCB1
+	viaIrqT2
+	viaIrqT1
+	viaIrqAny
+)
+
+// Modes of the CA2 and CB2 control lines, set in the PCR
+const (
+	viaControlNegativeEdge = iota
+	viaControlIndependentNegativeEdge
+	viaControlPositiveEdge
+	viaControlIndependentPositiveEdge
+	viaControlHandshake
+	viaControlPulse
+	viaControlLow
+	viaControlHigh
+)
+
+// Modes of the shift register, set in the ACR
+const (
+	viaShiftDisabled = iota
+	viaShiftInT2
+	viaShiftInClock
+	viaShiftInCB1
+	viaShiftOutFreeRunning
+	viaShiftOutT2
+	viaShiftOutClock
+	viaShiftOutCB1
+)
+
+/*
+VIA 6522 Versatile Interface Adapter
+
+The VIA provides two 8-bit bidirectional ports (A and B), each with a data
+direction register, two 16-bit timers, a shift register and the CA1, CA2,
+CB1 and CB2 control lines for handshaking.
+
+Timer 1 runs one-shot or free-running, optionally driving PB7. Timer 2
+runs one-shot or counts pulses on PB6. The VIA is Clocked: timers and the
+shift register advance with the clock cycles of the Cpu when attached to
+the AddressBus.
+
+Interrupts are signalled on the IrqLine given to AttachIrq.
+
+The world outside drives the input pins with SetPortA, SetPortB and
+SetCA1, SetCA2, SetCB1, SetCB2. The PortAChanged, PortBChanged, CA2Changed
+and CB2Changed hooks are called when the VIA changes its outputs.
+
+The pins can be driven from other goroutines than the Cpu's. The hooks
+are called without holding the VIA's lock, so they may drive the pins
+themselves.
+*/
+type Via6522 struct {
+	PortAChanged func(value byte) // Called with the pins of port A when the outputs change
+	PortBChanged func(value byte) // Called with the pins of port B when the outputs change
+	CA2Changed   func(level bool) // Called when CA2 is driven to a new level
+	CB2Changed   func(level bool) // Called when CB2 is driven to a new level
+
+	ora, orb       byte // Output registers
+	ddra, ddrb     byte // Data direction registers, 1 is output
+	inputA, inputB byte // Levels driven on the pins from outside
+	latchA, latchB byte // Inputs latched on an active CA1 or CB1 edge
+	portA, portB   byte // Pin levels, as last reported to the hooks
+
+	t1Counter uint16
+	t1Latch   uint16
+	t1Armed   bool // Interrupt on the next one-shot time-out
+	t1Reload  bool // Reload the counter from the latch on the next cycle
+	pb7       bool // Level of PB7 under Timer 1 control
+
+	t2Counter  uint16
+	t2LatchLow byte
+	t2Armed    bool // Interrupt on the next time-out
+
+	sr       byte
+	srBits   int // Bits left to shift, 0 when idle
+	srCycles int // Cycles until the next shift
+
+	acr byte // Auxiliary Control Register
+	pcr byte // Peripheral Control Register
+	ifr byte // Interrupt Flag Register
+	ier byte // Interrupt Enable Register
+
+	ca1, ca2, cb1, cb2 bool // Levels of the control lines
+	ca2Pulse, cb2Pulse bool // Pulse output, ends after one cycle
+
+	irq *IrqLine
+
+	mutex sync.Mutex
+	hooks []func() // Hook calls, made once the mutex is released
+}
+
+// Create a new Via6522, with all pins pulled high.
+func NewVia6522() (*Via6522, error) {
+	via := &Via6522{inputA: 0xFF, inputB: 0xFF, ca1: true, ca2: true, cb1: true, cb2: true}
+	via.Reset()
+
+	return via, nil
+}
+
+func (v *Via6522) Size() uint16 {
+	// 16 registers
+	return 0x10
+}
+
+// Emulates a hardware reset. All registers are cleared, except the timers
+// and the shift register.
+func (v *Via6522) Reset() {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	v.ora, v.orb = 0, 0
+	v.ddra, v.ddrb = 0, 0
+
+	v.acr = 0
+	v.pcr = 0
+	v.ifr = 0
+	v.ier = 0
+
+	v.t1Armed = false
+	v.t2Armed = false
+	v.pb7 = true
+	v.srBits = 0
+	v.ca2Pulse, v.cb2Pulse = false, false
+
+	v.updatePorts()
+	v.updateIrq()
+}
+
+// Signal interrupts on the given IrqLine.
+func (v *Via6522) AttachIrq(line *IrqLine) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	v.irq = line
+	v.updateIrq()
+}
+
+// Returns the levels of the pins of port A.
+func (v *Via6522) PortA() byte {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	return v.pinsA()
+}
+
+func (v *Via6522) pinsA() byte {
+	return v.ora&v.ddra | v.inputA&^v.ddra
+}
+
+// Returns the levels of the pins of port B. PB7 is driven by Timer 1
+// when enabled in the ACR.
+func (v *Via6522) PortB() byte {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	return v.pinsB()
+}
+
+func (v *Via6522) pinsB() byte {
+	pins := v.orb&v.ddrb | v.inputB&^v.ddrb
+
+	if v.acr&0x80 != 0 {
+		pins &^= 0x80
+		if v.pb7 {
+			pins |= 0x80
+		}
+	}
+
+	return pins
+}
+
+// Drive the input pins of port A. Only pins configured as input are affected.
+func (v *Via6522) SetPortA(value byte) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	v.inputA = value
+	v.portA = v.pinsA()
+}
+
+// Drive the input pins of port B. Only pins configured as input are
+// affected. Falling edges on PB6 are counted by Timer 2 in pulse counting mode.
+func (v *Via6522) SetPortB(value byte) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	pb6 := v.pinsB() & 0x40
+
+	v.inputB = value
+	v.portB = v.pinsB()
+
+	if v.acr&0x20 != 0 && pb6 != 0 && v.portB&0x40 == 0 {
+		v.pulseTimer2()
+	}
+}
+
+// Drive the CA1 input. The active edge is selected in the PCR.
+func (v *Via6522) SetCA1(level bool) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	if level == v.ca1 {
+		return
+	}
+	v.ca1 = level
+
+	if level != (v.pcr&0x01 != 0) {
+		return
+	}
+
+	if v.acr&0x01 != 0 {
+		v.latchA = v.pinsA()
+	}
+
+	if v.ca2Mode() == viaControlHandshake {
+		v.setCA2(true)
+	}
+
+	v.setIfr(viaIrqCA1)
+}
+
+// Drive the CA2 input, when CA2 is configured as input in the PCR.
+func (v *Via6522) SetCA2(level bool) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	mode := v.ca2Mode()
+	if mode >= viaControlHandshake || level == v.ca2 {
+		return
+	}
+	v.ca2 = level
+
+	if level == (mode&viaControlPositiveEdge != 0) {
+		v.setIfr(viaIrqCA2)
+	}
+}
+
+// Returns the level of CA2
+func (v *Via6522) CA2() bool {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	return v.ca2
+}
+
+// Drive the CB1 input. The active edge is selected in the PCR. CB1 also
+// clocks the shift register in the external clock modes.
+func (v *Via6522) SetCB1(level bool) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	if level == v.cb1 {
+		return
+	}
+	v.cb1 = level
+
+	switch v.shiftMode() {
+	case viaShiftInCB1:
+		if level {
+			v.shift()
+		}
+	case viaShiftOutCB1:
+		if !level {
+			v.shift()
+		}
+	}
+
+	if level != (v.pcr&0x10 != 0) {
+		return
+	}
+
+	if v.acr&0x02 != 0 {
+		v.latchB = v.pinsB()
+	}
+
+	if v.cb2Mode() == viaControlHandshake {
+		v.setCB2(true)
+	}
+
+	v.setIfr(viaIrqCB1)
+}
+
+// Drive the CB2 input, when CB2 is configured as input in the PCR. CB2
+// also provides the data in the shift in modes.
+func (v *Via6522) SetCB2(level bool) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	mode := v.cb2Mode()
+	if mode >= viaControlHandshake || level == v.cb2 {
+		return
+	}
+	v.cb2 = level
+
+	if level == (mode&viaControlPositiveEdge != 0) {
+		v.setIfr(viaIrqCB2)
+	}
+}
+
+// Returns the level of CB2
+func (v *Via6522) CB2() bool {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	return v.cb2
+}
+
+// Let the given number of clock cycles pass.
+func (v *Via6522) Tick(cycles uint8) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	for i := uint8(0); i < cycles; i++ {
+		v.cycle()
+	}
+}
+
+// Used by the AddressBus to read data from the VIA 6522
+func (v *Via6522) ReadByte(address uint16) byte {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	value := v.peek(address)
+
+	switch address {
+	case viaORB:
+		v.handshakeB(false)
+	case viaORA:
+		v.handshakeA()
+	case viaT1CL:
+		v.clearIfr(viaIrqT1)
+	case viaT2CL:
+		v.clearIfr(viaIrqT2)
+	case viaSR:
+		v.startShift()
+	}
+
+	return value
+}
+
+// Returns the same as ReadByte, without clearing interrupt flags or
+// handshaking.
+func (v *Via6522) Peek(address uint16) byte {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	return v.peek(address)
+}
+
+func (v *Via6522) peek(address uint16) byte {
+	switch address {
+	case viaORB:
+		if v.acr&0x02 != 0 {
+			return v.orb&v.ddrb | v.latchB&^v.ddrb
+		}
+		return v.pinsB()
+	case viaORA, viaORANoHandshake:
+		if v.acr&0x01 != 0 {
+			return v.latchA
+		}
+		return v.pinsA()
+	case viaDDRB:
+		return v.ddrb
+	case viaDDRA:
+		return v.ddra
+	case viaT1CL:
+		return byte(v.t1Counter)
+	case viaT1CH:
+		return byte(v.t1Counter >> 8)
+	case viaT1LL:
+		return byte(v.t1Latch)
+	case viaT1LH:
+		return byte(v.t1Latch >> 8)
+	case viaT2CL:
+		return byte(v.t2Counter)
+	case viaT2CH:
+		return byte(v.t2Counter >> 8)
+	case viaSR:
+		return v.sr
+	case viaACR:
+		return v.acr
+	case viaPCR:
+		return v.pcr
+	case viaIFR:
+		return v.ifr
+	case viaIER:
+		return v.ier | 0x80
+	}
+
+	return 0x00
+}
+
+// Used by the AddressBus to write data to the VIA 6522
+func (v *Via6522) WriteByte(address uint16, data byte) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	switch address {
+	case viaORB:
+		v.orb = data
+		v.handshakeB(true)
+	case viaORA:
+		v.ora = data
+		v.handshakeA()
+	case viaORANoHandshake:
+		v.ora = data
+	case viaDDRB:
+		v.ddrb = data
+	case viaDDRA:
+		v.ddra = data
+	case viaT1CL, viaT1LL:
+		v.t1Latch = v.t1Latch&0xFF00 | uint16(data)
+	case viaT1CH:
+		v.t1Latch = uint16(data)<<8 | v.t1Latch&0x00FF
+		v.t1Counter = v.t1Latch
+		v.t1Armed = true
+		v.t1Reload = false
+		v.pb7 = false
+		v.clearIfr(viaIrqT1)
+	case viaT1LH:
+		v.t1Latch = uint16(data)<<8 | v.t1Latch&0x00FF
+		v.clearIfr(viaIrqT1)
+	case viaT2CL:
+		v.t2LatchLow = data
+	case viaT2CH:
+		v.t2Counter = uint16(data)<<8 | uint16(v.t2LatchLow)
+		v.t2Armed = true
+		v.clearIfr(viaIrqT2)
+	case viaSR:
+		v.sr = data
+		v.startShift()
+	case viaACR:
+		v.acr = data
+	case viaPCR:
+		v.setPcr(data)
+	case viaIFR:
+		v.ifr &^= data & 0x7F
+		v.updateIfr()
+	case viaIER:
+		if data&0x80 != 0 {
+			v.ier |= data & 0x7F
+		} else {
+			v.ier &^= data & 0x7F
+		}
+		v.updateIfr()
+	}
+
+	v.updatePorts()
+}
+
+// Releases the mutex, then calls the hooks for the outputs that changed
+// while it was held.
+func (v *Via6522) unlock() {
+	hooks := v.hooks
+	v.hooks = nil
+	v.mutex.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+/*
+Sets the registers read by Peek, for debuggers. Timers and the shift
+register don't start, interrupt flags are not cleared and there is no
+handshaking. Poking T1C-L and T2C-L sets the low byte of the counter.
+Hooks are still called when the outputs change.
+*/
+func (v *Via6522) Poke(address uint16, data byte) {
+	v.mutex.Lock()
+	defer v.unlock()
+
+	switch address {
+	case viaORB:
+		v.orb = data
+	case viaORA, viaORANoHandshake:
+		v.ora = data
+	case viaDDRB:
+		v.ddrb = data
+	case viaDDRA:
+		v.ddra = data
+	case viaT1CL:
+		v.t1Counter = v.t1Counter&0xFF00 | uint16(data)
+	case viaT1CH:
+		v.t1Counter = uint16(data)<<8 | v.t1Counter&0x00FF
+	case viaT1LL:
+		v.t1Latch = v.t1Latch&0xFF00 | uint16(data)
+	case viaT1LH:
+		v.t1Latch = uint16(data)<<8 | v.t1Latch&0x00FF
+	case viaT2CL:
+		v.t2Counter = v.t2Counter&0xFF00 | uint16(data)
+	case viaT2CH:
+		v.t2Counter = uint16(data)<<8 | v.t2Counter&0x00FF
+	case viaSR:
+		v.sr = data
+	case viaACR:
+		v.acr = data
+	case viaPCR:
+		v.pcr = data
+	case viaIFR:
+		v.ifr = data & 0x7F
+		v.updateIfr()
+	case viaIER:
+		v.ier = data & 0x7F
+		v.updateIfr()
+	}
+
+	v.updatePorts()
+}
+
+func (v *Via6522) ca2Mode() byte {
+	return (v.pcr >> 1) & 0x07
+}
+
+func (v *Via6522) cb2Mode() byte {
+	return (v.pcr >> 5) & 0x07
+}
+
+func (v *Via6522) shiftMode() byte {
+	return (v.acr >> 2) & 0x07
+}
+
+func (v *Via6522) setPcr(data byte) {
+	v.pcr = data
+
+	switch v.ca2Mode() {
+	case viaControlHandshake, viaControlPulse, viaControlHigh:
+		v.setCA2(true)
+	case viaControlLow:
+		v.setCA2(false)
+	}
+
+	switch v.cb2Mode() {
+	case viaControlHandshake, viaControlPulse, viaControlHigh:
+		v.setCB2(true)
+	case viaControlLow:
+		v.setCB2(false)
+	}
+}
+
+// Drives CA2 as output
+func (v *Via6522) setCA2(level bool) {
+	if level == v.ca2 {
+		return
+	}
+	v.ca2 = level
+
+	if hook := v.CA2Changed; hook != nil {
+		v.hooks = append(v.hooks, func() { hook(level) })
+	}
+}
+
+// Drives CB2 as output
+func (v *Via6522) setCB2(level bool) {
+	if level == v.cb2 {
+		return
+	}
+	v.cb2 = level
+
+	if hook := v.CB2Changed; hook != nil {
+		v.hooks = append(v.hooks, func() { hook(level) })
+	}
+}
+
+// Reading or writing ORA clears the CA1 and CA2 flags and signals CA2 in
+// the handshake and pulse modes.
+func (v *Via6522) handshakeA() {
+	mode := v.ca2Mode()
+
+	v.clearIfr(viaIrqCA1)
+	if mode != viaControlIndependentNegativeEdge && mode != viaControlIndependentPositiveEdge {
+		v.clearIfr(viaIrqCA2)
+	}
+
+	switch mode {
+	case viaControlHandshake:
+		v.setCA2(false)
+	case viaControlPulse:
+		v.setCA2(false)
+		v.ca2Pulse = true
+	}
+}
+
+// Reading or writing ORB clears the CB1 and CB2 flags. Only writing
+// signals CB2 in the handshake and pulse modes.
+func (v *Via6522) handshakeB(write bool) {
+	mode := v.cb2Mode()
+
+	v.clearIfr(viaIrqCB1)
+	if mode != viaControlIndependentNegativeEdge && mode != viaControlIndependentPositiveEdge {
+		v.clearIfr(viaIrqCB2)
+	}
+
+	if !write {
+		return
+	}
+
+	switch mode {
+	case viaControlHandshake:
+		v.setCB2(false)
+	case viaControlPulse:
+		v.setCB2(false)
+		v.cb2Pulse = true
+	}
+}
+
+// A single clock cycle
+func (v *Via6522) cycle() {
+	if v.ca2Pulse {
+		v.ca2Pulse = false
+		v.setCA2(true)
+	}
+
+	if v.cb2Pulse {
+		v.cb2Pulse = false
+		v.setCB2(true)
+	}
+
+	v.cycleTimer1()
+
+	if v.acr&0x20 == 0 {
+		v.cycleTimer2()
+	}
+
+	v.cycleShiftRegister()
+}
+
+// Timer 1 interrupts when counting down past zero. In free-running mode
+// it is reloaded from the latch and PB7 is toggled, giving a period of
+// latch + 2 cycles. In one-shot mode, PB7 goes high once.
+func (v *Via6522) cycleTimer1() {
+	if v.t1Reload {
+		v.t1Reload = false
+		v.t1Counter = v.t1Latch
+		return
+	}
+
+	v.t1Counter--
+	if v.t1Counter != 0xFFFF {
+		return
+	}
+
+	if v.acr&0x40 != 0 {
+		v.t1Reload = true
+		v.pb7 = !v.pb7
+		v.setIfr(viaIrqT1)
+	} else if v.t1Armed {
+		v.t1Armed = false
+		v.pb7 = true
+		v.setIfr(viaIrqT1)
+	}
+
+	v.updatePorts()
+}
+
+// Timer 2 in one-shot mode interrupts once when counting down past zero.
+func (v *Via6522) cycleTimer2() {
+	v.t2Counter--
+
+	if v.t2Counter == 0xFFFF && v.t2Armed {
+		v.t2Armed = false
+		v.setIfr(viaIrqT2)
+	}
+}
+
+// Timer 2 in pulse counting mode interrupts once when reaching zero.
+func (v *Via6522) pulseTimer2() {
+	v.t2Counter--
+
+	if v.t2Counter == 0 && v.t2Armed {
+		v.t2Armed = false
+		v.setIfr(viaIrqT2)
+	}
+}
+
+// Reading or writing the shift register starts shifting 8 bits.
+func (v *Via6522) startShift() {
+	v.clearIfr(viaIrqSR)
+
+	if v.shiftMode() != viaShiftDisabled {
+		v.srBits = 8
+		v.srCycles = v.shiftPeriod()
+	}
+}
+
+// Returns the number of cycles per bit for internally clocked modes.
+func (v *Via6522) shiftPeriod() int {
+	switch v.shiftMode() {
+	case viaShiftInT2, viaShiftOutFreeRunning, viaShiftOutT2:
+		return 2 * (int(v.t2LatchLow) + 2)
+	}
+
+	return 2
+}
+
+func (v *Via6522) cycleShiftRegister() {
+	switch v.shiftMode() {
+	case viaShiftDisabled, viaShiftInCB1, viaShiftOutCB1:
+		return
+	}
+
+	if v.srBits == 0 {
+		return
+	}
+
+	v.srCycles--
+	if v.srCycles > 0 {
+		return
+	}
+
+	v.srCycles = v.shiftPeriod()
+	v.shift()
+}
+
+// Shifts a single bit in from CB2, or out to CB2. Bits shifted out are
+// rotated back in.
+func (v *Via6522) shift() {
+	if v.srBits == 0 {
+		return
+	}
+
+	mode := v.shiftMode()
+
+	if mode >= viaShiftOutFreeRunning {
+		bit := v.sr >> 7
+		v.sr = v.sr<<1 | bit
+		v.setCB2(bit != 0)
+	} else {
+		v.sr <<= 1
+		if v.cb2 {
+			v.sr |= 0x01
+		}
+	}
+
+	v.srBits--
+	if v.srBits > 0 {
+		return
+	}
+
+	if mode == viaShiftOutFreeRunning {
+		v.srBits = 8
+	} else {
+		v.setIfr(viaIrqSR)
+	}
+}
+
+func (v *Via6522) setIfr(flags byte) {
+	v.ifr |= flags
+	v.updateIfr()
+}
+
+func (v *Via6522) clearIfr(flags byte) {
+	v.ifr &^= flags
+	v.updateIfr()
+}
+
+// Bit 7 of the IFR is set when any enabled interrupt flag is set, which
+// pulls the IRQ line low.
+func (v *Via6522) updateIfr() {
+	if v.ifr&v.ier&0x7F != 0 {
+		v.ifr |= viaIrqAny
+	} else {
+		v.ifr &^= viaIrqAny
+	}
+
+	v.updateIrq()
+}
+
+func (v *Via6522) updateIrq() {
+	if v.irq == nil {
+		return
+	}
+
+	if v.ifr&viaIrqAny != 0 {
+		v.irq.Assert(v)
+	} else {
+		v.irq.Release(v)
+	}
+}
+
+// Calls the hooks for ports that changed
+func (v *Via6522) updatePorts() {
+	if portA := v.pinsA(); portA != v.portA {
+		v.portA = portA
+		if hook := v.PortAChanged; hook != nil {
+			v.hooks = append(v.hooks, func() { hook(portA) })
+		}
+	}
+
+	if portB := v.pinsB(); portB != v.portB {
+		v.portB = portB
+		if hook := v.PortBChanged; hook != nil {
+			v.hooks = append(v.hooks, func() { hook(portB) })
+		}
+	}
+}
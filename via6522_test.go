@@ -0,0 +1,372 @@
+package i6502
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ViaSubject() (*Via6522, *IrqLine) {
+	via, _ := NewVia6522()
+	irq, _ := NewIrqLine()
+	via.AttachIrq(irq)
+
+	return via, irq
+}
+
+func TestNewVia6522(t *testing.T) {
+	via, err := NewVia6522()
+
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0x10, via.Size())
+	assert.EqualValues(t, 0xFF, via.PortA())
+	assert.EqualValues(t, 0xFF, via.PortB())
+}
+
+func TestViaAsMemory(t *testing.T) {
+	assert.Implements(t, (*Memory)(nil), new(Via6522))
+	assert.Implements(t, (*Clocked)(nil), new(Via6522))
+	assert.Implements(t, (*Peeker)(nil), new(Via6522))
+}
+
+func TestViaPorts(t *testing.T) {
+	via, _ := ViaSubject()
+
+	var portA, portB []byte
+	via.PortAChanged = func(value byte) { portA = append(portA, value) }
+	via.PortBChanged = func(value byte) { portB = append(portB, value) }
+
+	via.SetPortA(0x5A)
+	via.SetPortB(0x00)
+	assert.EqualValues(t, 0x5A, via.ReadByte(viaORA))
+	assert.EqualValues(t, 0x00, via.ReadByte(viaORB))
+
+	// Outputs only drive pins configured as output
+	via.WriteByte(viaORA, 0xFF)
+	assert.Nil(t, portA)
+
+	via.WriteByte(viaDDRA, 0x0F)
+	assert.EqualValues(t, 0x0F, via.ReadByte(viaDDRA))
+	assert.EqualValues(t, 0x5F, via.ReadByte(viaORA))
+	assert.Equal(t, []byte{0x5F}, portA)
+
+	via.WriteByte(viaDDRB, 0xFF)
+	via.WriteByte(viaORB, 0x81)
+	assert.EqualValues(t, 0x81, via.PortB())
+	assert.Equal(t, []byte{0x81}, portB)
+
+	via.Reset()
+	assert.EqualValues(t, 0x00, via.ReadByte(viaDDRA))
+	assert.EqualValues(t, 0x5A, via.PortA())
+}
+
+func TestViaInterruptRegisters(t *testing.T) {
+	via, irq := ViaSubject()
+
+	via.WriteByte(viaIER, 0x80|viaIrqCA1|viaIrqT1)
+	assert.EqualValues(t, 0x80|viaIrqCA1|viaIrqT1, via.ReadByte(viaIER))
+
+	via.WriteByte(viaIER, viaIrqT1)
+	assert.EqualValues(t, 0x80|viaIrqCA1, via.ReadByte(viaIER))
+
+	// Disabled flags are set, but don't interrupt
+	via.SetCB1(false)
+	assert.EqualValues(t, viaIrqCB1, via.ReadByte(viaIFR))
+	assert.False(t, irq.Asserted())
+
+	via.SetCA1(false)
+	assert.EqualValues(t, viaIrqAny|viaIrqCB1|viaIrqCA1, via.ReadByte(viaIFR))
+	assert.True(t, irq.Asserted())
+
+	// Writing a 1 clears the flag
+	via.WriteByte(viaIFR, viaIrqCA1)
+	assert.EqualValues(t, viaIrqCB1, via.ReadByte(viaIFR))
+	assert.False(t, irq.Asserted())
+}
+
+func TestViaPoke(t *testing.T) {
+	via, irq := ViaSubject()
+	assert.Implements(t, (*Poker)(nil), via)
+	via.WriteByte(viaIER, 0x80|viaIrqT1)
+	via.WriteByte(viaIFR, 0x7F)
+
+	// Poking T1C-H neither starts Timer 1, nor clears its flag
+	via.Poke(viaIFR, viaIrqT1)
+	assert.True(t, irq.Asserted())
+	via.Poke(viaT1CL, 0x02)
+	via.Poke(viaT1CH, 0x00)
+	assert.EqualValues(t, 0x02, via.Peek(viaT1CL))
+	assert.EqualValues(t, viaIrqAny|viaIrqT1, via.Peek(viaIFR))
+
+	via.Poke(viaIFR, 0x00)
+	via.Tick(10)
+	assert.EqualValues(t, 0x00, via.Peek(viaIFR))
+	assert.False(t, irq.Asserted())
+
+	// Poking ORA does not handshake on CA2
+	via.WriteByte(viaPCR, 0x0A) // CA2 pulse output
+	var ca2 []bool
+	via.CA2Changed = func(level bool) { ca2 = append(ca2, level) }
+	via.WriteByte(viaDDRA, 0xFF)
+	via.Poke(viaORA, 0x42)
+	assert.EqualValues(t, 0x42, via.PortA())
+	assert.Nil(t, ca2)
+
+	// Poking the shift register does not start shifting
+	via.Poke(viaACR, 0x18) // Shift out under Phi2
+	via.Poke(viaSR, 0x81)
+	via.Tick(20)
+	assert.EqualValues(t, 0x81, via.Peek(viaSR))
+}
+
+func TestViaTimer1OneShot(t *testing.T) {
+	via, irq := ViaSubject()
+	via.WriteByte(viaIER, 0x80|viaIrqT1)
+
+	via.WriteByte(viaT1CL, 0x10)
+	via.WriteByte(viaT1CH, 0x00)
+	assert.EqualValues(t, 0x10, via.ReadByte(viaT1CL))
+
+	via.Tick(0x10)
+	assert.EqualValues(t, 0x00, via.ReadByte(viaT1CL))
+	assert.False(t, irq.Asserted())
+
+	via.Tick(1)
+	assert.True(t, irq.Asserted())
+
+	// Reading T1C-L clears the interrupt
+	assert.EqualValues(t, 0xFF, via.ReadByte(viaT1CL))
+	assert.False(t, irq.Asserted())
+
+	// One-shot does not interrupt again
+	via.Tick(0xFF)
+	via.Tick(0xFF)
+	assert.EqualValues(t, 0x00, via.Peek(viaIFR))
+}
+
+func TestViaTimer1FreeRun(t *testing.T) {
+	via, _ := ViaSubject()
+	via.WriteByte(viaACR, 0xC0)
+	via.WriteByte(viaT1LL, 0x04)
+	via.WriteByte(viaT1CH, 0x00)
+
+	// PB7 is low while counting
+	assert.EqualValues(t, 0x00, via.PortB()&0x80)
+
+	var pb7 []bool
+	via.PortBChanged = func(value byte) { pb7 = append(pb7, value&0x80 != 0) }
+
+	// Interrupt after 5 cycles, then every latch + 2 cycles
+	via.Tick(5)
+	assert.EqualValues(t, viaIrqT1, via.Peek(viaIFR))
+	via.ReadByte(viaT1CL)
+
+	via.Tick(5)
+	assert.EqualValues(t, 0x00, via.Peek(viaIFR))
+	via.Tick(1)
+	assert.EqualValues(t, viaIrqT1, via.Peek(viaIFR))
+
+	assert.Equal(t, []bool{true, false}, pb7)
+}
+
+func TestViaTimer2(t *testing.T) {
+	via, irq := ViaSubject()
+	via.WriteByte(viaIER, 0x80|viaIrqT2)
+
+	via.WriteByte(viaT2CL, 0x02)
+	via.WriteByte(viaT2CH, 0x00)
+	via.Tick(2)
+	assert.False(t, irq.Asserted())
+	via.Tick(1)
+	assert.True(t, irq.Asserted())
+
+	via.ReadByte(viaT2CL)
+	assert.False(t, irq.Asserted())
+
+	// Count pulses on PB6
+	via.WriteByte(viaACR, 0x20)
+	via.WriteByte(viaT2CL, 0x02)
+	via.WriteByte(viaT2CH, 0x00)
+	via.Tick(10)
+	assert.EqualValues(t, 0x02, via.Peek(viaT2CL))
+
+	via.SetPortB(0xBF)
+	via.SetPortB(0xFF)
+	assert.False(t, irq.Asserted())
+	via.SetPortB(0xBF)
+	assert.True(t, irq.Asserted())
+}
+
+func TestViaShiftOut(t *testing.T) {
+	via, irq := ViaSubject()
+	via.WriteByte(viaIER, 0x80|viaIrqSR)
+
+	var cb2 []bool
+	via.CB2Changed = func(level bool) { cb2 = append(cb2, level) }
+
+	// Shift out under system clock, a bit every 2 cycles
+	via.WriteByte(viaACR, viaShiftOutClock<<2)
+	via.WriteByte(viaSR, 0x2A)
+
+	via.Tick(15)
+	assert.False(t, irq.Asserted())
+	via.Tick(1)
+	assert.True(t, irq.Asserted())
+
+	assert.Equal(t, []bool{false, true, false, true, false, true, false}, cb2)
+	assert.EqualValues(t, 0x2A, via.ReadByte(viaSR))
+	assert.False(t, irq.Asserted())
+}
+
+func TestViaShiftIn(t *testing.T) {
+	via, _ := ViaSubject()
+
+	// Shift in under external clock on CB1
+	via.WriteByte(viaACR, viaShiftInCB1<<2)
+	via.ReadByte(viaSR)
+
+	for _, bit := range []bool{true, false, true, true, false, false, true, false} {
+		via.SetCB2(bit)
+		via.SetCB1(false)
+		via.SetCB1(true)
+	}
+
+	assert.EqualValues(t, viaIrqSR|viaIrqCB1, via.Peek(viaIFR)&(viaIrqSR|viaIrqCB1))
+	assert.EqualValues(t, 0xB2, via.ReadByte(viaSR))
+}
+
+func TestViaHandshake(t *testing.T) {
+	via, _ := ViaSubject()
+
+	// CA1 on positive edge with input latching, CA2 handshake output
+	via.WriteByte(viaPCR, 0x01|viaControlHandshake<<1)
+	via.WriteByte(viaACR, 0x01)
+	assert.True(t, via.CA2())
+
+	via.SetCA1(false)
+	via.SetPortA(0x42)
+	via.SetCA1(true)
+	via.SetPortA(0x00)
+
+	assert.EqualValues(t, viaIrqCA1, via.Peek(viaIFR))
+	assert.EqualValues(t, 0x42, via.Peek(viaORANoHandshake))
+	assert.EqualValues(t, viaIrqCA1, via.Peek(viaIFR))
+
+	// Reading ORA clears the flag and signals data taken on CA2
+	assert.EqualValues(t, 0x42, via.ReadByte(viaORA))
+	assert.EqualValues(t, 0x00, via.Peek(viaIFR))
+	assert.False(t, via.CA2())
+
+	// Data ready on CA1 again
+	via.SetCA1(false)
+	via.SetCA1(true)
+	assert.True(t, via.CA2())
+
+	// CB2 pulse output on writing ORB
+	var cb2 []bool
+	via.CB2Changed = func(level bool) { cb2 = append(cb2, level) }
+	via.WriteByte(viaPCR, viaControlPulse<<5)
+	via.WriteByte(viaORB, 0x42)
+	via.Tick(1)
+	assert.Equal(t, []bool{false, true}, cb2)
+
+	// Manual CB2 output
+	via.WriteByte(viaPCR, viaControlLow<<5)
+	assert.False(t, via.CB2())
+}
+
+func TestViaIndependentCA2(t *testing.T) {
+	via, _ := ViaSubject()
+	via.WriteByte(viaPCR, viaControlIndependentPositiveEdge<<1)
+
+	via.SetCA2(false)
+	assert.EqualValues(t, 0x00, via.Peek(viaIFR))
+	via.SetCA2(true)
+	assert.EqualValues(t, viaIrqCA2, via.Peek(viaIFR))
+
+	// Not cleared by reading ORA
+	via.ReadByte(viaORA)
+	assert.EqualValues(t, viaIrqCA2, via.Peek(viaIFR))
+}
+
+func TestViaIntegration(t *testing.T) {
+	// * 64kB RAM at 0x0000-FFFF
+	// * VIA 6522 at 0x8000-800F, shadowing Ram
+	cpu, bus, _ := NewRamMachine()
+	via, _ := NewVia6522()
+	bus.AttachOverlay(via, 0x8000)
+	via.AttachIrq(cpu.Irq)
+
+	var output []byte
+	via.PortBChanged = func(value byte) { output = append(output, value) }
+
+	// IRQ handler at 0x0300 writes to port B and acknowledges
+	bus.Write16(IrqVector, 0x0300)
+	program := []byte{
+		0xA9, 0xFF, // LDA #$FF
+		0x8D, 0x02, 0x80, // STA $8002 (DDRB)
+		0xA9, 0xC0, // LDA #$C0
+		0x8D, 0x0E, 0x80, // STA $800E (IER, enable T1)
+		0xA9, 0x20, // LDA #$20
+		0x8D, 0x04, 0x80, // STA $8004 (T1C-L)
+		0xA9, 0x00, // LDA #$00
+		0x8D, 0x05, 0x80, // STA $8005 (T1C-H, start)
+		0x58,             // CLI
+		0x4C, 0x15, 0x02, // JMP *
+	}
+	handler := []byte{
+		0xEE, 0x00, 0x80, // INC $8000 (ORB)
+		0xAD, 0x04, 0x80, // LDA $8004 (Acknowledge T1)
+		0x40, // RTI
+	}
+	cpu.LoadProgram(handler, 0x0300)
+	cpu.LoadProgram(program, 0x0200)
+
+	_, err := cpu.RunCycles(200)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x00, 0x01}, output)
+	assert.False(t, cpu.Irq.Asserted())
+}
+
+func TestViaPinsConcurrent(t *testing.T) {
+	via, _ := ViaSubject()
+	via.WriteByte(viaACR, 0x20) // Count pulses on PB6
+	via.WriteByte(viaT2CL, 0xFF)
+	via.WriteByte(viaT2CH, 0xFF)
+	done := make(chan bool)
+
+	go func() {
+		for i := 0; i < 1000; i++ {
+			via.SetPortB(byte(i) & 0x40)
+			via.SetCA1(i&1 == 0)
+		}
+		done <- true
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			via.Tick(1)
+			via.ReadByte(viaIFR)
+		}
+	}
+
+	// PB6 falls on the first write, and every 128 writes after that
+	assert.EqualValues(t, 0xF7, via.Peek(viaT2CL))
+	assert.EqualValues(t, 0xFF, via.Peek(viaT2CH))
+}
+
+func TestViaHooksDrivePins(t *testing.T) {
+	via, _ := ViaSubject()
+	via.WriteByte(viaPCR, 0x09) // CA2 handshake, CA1 positive edge
+
+	// Data is taken as soon as it's read
+	via.CA2Changed = func(level bool) { via.SetCA1(!level) }
+	via.SetCA1(false)
+
+	via.ReadByte(viaORA)
+	assert.True(t, via.CA2())
+	assert.EqualValues(t, viaIrqCA1, via.ReadByte(viaIFR)&viaIrqCA1)
+}